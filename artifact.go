@@ -0,0 +1,189 @@
+/*
+ * Copyright (C) 2018-Present Pivotal Software, Inc. All rights reserved.
+ *
+ * This program and the accompanying materials are made available under
+ * the terms of the under the Apache License, Version 2.0 (the "License”);
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package main
+
+import (
+	"archive/zip"
+	"crypto/sha256"
+	"crypto/tls"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Fetch a user supplied tunnel host artifact (a local jar, zip or directory, or an
+// http(s) URL to a jar or zip) and check it looks like something cf can push.
+// Returns the local path to push from. Downloads skip certificate checks when the
+// cf CLI does (cf api --skip-ssl-validation).
+func resolveTunnelApplication(tempDir string, location string, skipSslValidation bool) (string, error) {
+	artifactPath := location
+	if strings.HasPrefix(location, "http://") || strings.HasPrefix(location, "https://") {
+		downloaded, err := downloadArtifact(tempDir, location, skipSslValidation)
+		if err != nil {
+			return "", err
+		}
+		artifactPath = downloaded
+	}
+	artifactPath, err := filepath.Abs(artifactPath)
+	if err != nil {
+		return "", err
+	}
+	if err = validateArtifact(artifactPath); err != nil {
+		return "", err
+	}
+	fmt.Println("Using tunnel application", artifactPath)
+	return artifactPath, nil
+}
+
+// A server that stops answering fails the download rather than hanging push-tunnel-app, the overall
+// limit is generous as jars can be large
+const (
+	artifactResponseTimeout = 30 * time.Second
+	artifactDownloadTimeout = 10 * time.Minute
+)
+
+func downloadArtifact(tempDir string, url string, skipSslValidation bool) (string, error) {
+	fmt.Println("Downloading tunnel application from", url)
+	client := &http.Client{
+		Timeout: artifactDownloadTimeout,
+		Transport: &http.Transport{
+			Proxy:                 http.ProxyFromEnvironment,
+			TLSClientConfig:       &tls.Config{InsecureSkipVerify: skipSslValidation},
+			TLSHandshakeTimeout:   artifactResponseTimeout,
+			ResponseHeaderTimeout: artifactResponseTimeout,
+		},
+	}
+	resp, err := client.Get(url)
+	if err != nil {
+		return "", fmt.Errorf("Unable to download tunnel application: %s", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("Unable to download tunnel application from %s: %s", url, resp.Status)
+	}
+
+	name := path.Base(strings.SplitN(url, "?", 2)[0])
+	if name == "" || name == "/" || name == "." {
+		name = "tunnelapp.jar"
+	}
+	artifactFile := filepath.Join(tempDir, name)
+	out, err := os.Create(artifactFile)
+	if err != nil {
+		return "", err
+	}
+	defer out.Close()
+	if _, err = io.Copy(out, resp.Body); err != nil {
+		return "", fmt.Errorf("Unable to download tunnel application: %s", err)
+	}
+	return artifactFile, nil
+}
+
+func validateArtifact(artifactPath string) error {
+	info, err := os.Stat(artifactPath)
+	if err != nil {
+		return fmt.Errorf("Tunnel application %s cannot be read: %s", artifactPath, err)
+	}
+	if info.IsDir() {
+		entries, err := ioutil.ReadDir(artifactPath)
+		if err != nil {
+			return err
+		}
+		if len(entries) == 0 {
+			return fmt.Errorf("Tunnel application directory %s is empty", artifactPath)
+		}
+		return nil
+	}
+
+	extension := strings.ToLower(filepath.Ext(artifactPath))
+	if extension != ".jar" && extension != ".zip" {
+		return fmt.Errorf("Tunnel application %s must be a .jar, a .zip or a directory", artifactPath)
+	}
+	archive, err := zip.OpenReader(artifactPath)
+	if err != nil {
+		return fmt.Errorf("Tunnel application %s is not a valid archive: %s", artifactPath, err)
+	}
+	defer archive.Close()
+	if len(archive.File) == 0 {
+		return fmt.Errorf("Tunnel application archive %s is empty", artifactPath)
+	}
+	if extension == ".jar" {
+		for _, entry := range archive.File {
+			if entry.Name == "META-INF/MANIFEST.MF" {
+				return nil
+			}
+		}
+		return fmt.Errorf("Tunnel application %s has no META-INF/MANIFEST.MF, is it a runnable jar?", artifactPath)
+	}
+	return nil
+}
+
+// Compute the sha256 of the artifact. For a directory the digest covers the relative
+// path and contents of each file, visited in a stable order.
+func checksumArtifact(artifactPath string) (string, error) {
+	digest := sha256.New()
+	info, err := os.Stat(artifactPath)
+	if err != nil {
+		return "", err
+	}
+	if !info.IsDir() {
+		if err = copyFileInto(digest, artifactPath); err != nil {
+			return "", err
+		}
+		return hex.EncodeToString(digest.Sum(nil)), nil
+	}
+
+	var files []string
+	err = filepath.Walk(artifactPath, func(file string, fileInfo os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if fileInfo.Mode().IsRegular() {
+			files = append(files, file)
+		}
+		return nil
+	})
+	if err != nil {
+		return "", err
+	}
+	sort.Strings(files)
+	for _, file := range files {
+		relative, _ := filepath.Rel(artifactPath, file)
+		io.WriteString(digest, filepath.ToSlash(relative)+"\x00")
+		if err = copyFileInto(digest, file); err != nil {
+			return "", err
+		}
+	}
+	return hex.EncodeToString(digest.Sum(nil)), nil
+}
+
+func copyFileInto(w io.Writer, file string) error {
+	f, err := os.Open(file)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	_, err = io.Copy(w, f)
+	return err
+}
@@ -58,6 +58,10 @@ func (ac *ArgConsumer) ConsumeOptional(arg int, argDescription string) string {
 	return ac.positionalArgs[arg]
 }
 
+func (ac *ArgConsumer) Command() string {
+	return ac.command
+}
+
 func (ac *ArgConsumer) CheckAllConsumed() {
 	if len(ac.consumed) < len(ac.positionalArgs) {
 		extra := []string{}
@@ -0,0 +1,167 @@
+/*
+ * Copyright (C) 2018-Present Pivotal Software, Inc. All rights reserved.
+ *
+ * This program and the accompanying materials are made available under
+ * the terms of the under the Apache License, Version 2.0 (the "License”);
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"unicode/utf16"
+
+	"github.com/aclement/tunnel-boot/cli"
+	"github.com/aclement/tunnel-boot/format"
+	"github.com/aclement/tunnel-boot/scs"
+)
+
+// Build a client for the config server bound to the tunnel application
+func (p *Plugin) configServerClient(applicationName string) *scs.Client {
+	return p.serviceClient(applicationName, scs.ConfigServerLabels)
+}
+
+// The text to encrypt or decrypt comes either from the positional argument or the --file flag
+func getValueOrFile(argsConsumer *cli.ArgConsumer, fileName string, valueDescription string) string {
+	value := argsConsumer.ConsumeOptional(2, valueDescription)
+	argsConsumer.CheckAllConsumed()
+	if len(fileName) == 0 {
+		if len(value) == 0 {
+			argsConsumer.Consume(2, valueDescription)
+		}
+		return value
+	}
+	if len(value) != 0 {
+		diagnoseWithHelp("Incorrect usage: specify either "+valueDescription+" or --file, not both.", argsConsumer.Command())
+	}
+	data, err := ioutil.ReadFile(fileName)
+	if err != nil {
+		format.Diagnose(fmt.Sprintf("Error reading file: %s", err), os.Stderr, func() {
+			os.Exit(1)
+		})
+	}
+	return string(data)
+}
+
+func (p *Plugin) configServerEncrypt(applicationName string, value string) func() (string, error) {
+	return func() (string, error) {
+		cipherText, err := p.configServerClient(applicationName).Encrypt(value)
+		if err != nil {
+			return "", err
+		}
+		return scs.CipherPrefix + cipherText, nil
+	}
+}
+
+func (p *Plugin) configServerDecrypt(applicationName string, cipherText string) func() (string, error) {
+	return func() (string, error) {
+		return p.configServerClient(applicationName).Decrypt(cipherText)
+	}
+}
+
+const (
+	resolvedConfigProfile = "tunnel"
+	resolvedConfigFile    = "application-" + resolvedConfigProfile + ".properties"
+)
+
+// Fetch what the bound config server would serve the tunnel application (as spring.application.name,
+// in the cloud profile) and write it out as a properties file for the local app, noting the property
+// source each value came from.
+func (p *Plugin) resolveConfig(applicationName string, targetDir string) {
+	springApplicationName := springApplicationNameFromEnvironment(p.deployer.GetUserProvidedEnv(applicationName), applicationName)
+	fmt.Println("Resolving config server properties for", springApplicationName, "in profile cloud")
+	environment, err := p.configServerClient(applicationName).Environment(springApplicationName, "cloud")
+	if err != nil {
+		format.Diagnose(string(err.Error()), os.Stderr, func() {
+			os.Exit(1)
+		})
+	}
+
+	sourceOf := make(map[string]string)
+	values := make(map[string]string)
+	for _, propertySource := range environment.PropertySources {
+		for key, value := range propertySource.Source {
+			if _, defined := values[key]; !defined {
+				values[key] = fmt.Sprint(value)
+				sourceOf[key] = propertySource.Name
+			}
+		}
+	}
+	keys := make([]string, 0, len(values))
+	for key := range values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	contents := &bytes.Buffer{}
+	fmt.Fprintf(contents, "# Config server properties for %s (profiles: %s", environment.Name, strings.Join(environment.Profiles, ","))
+	if environment.Label != "" {
+		fmt.Fprintf(contents, ", label: %s", environment.Label)
+	}
+	if environment.Version != "" {
+		fmt.Fprintf(contents, ", version: %s", environment.Version)
+	}
+	fmt.Fprintln(contents, ")")
+	fmt.Fprintf(contents, "# Generated by 'cf get-local-env %s --resolve-config'\n", applicationName)
+	for _, key := range keys {
+		fmt.Fprintf(contents, "\n# from %s\n%s=%s\n", sourceOf[key], escapeProperty(key, true), escapeProperty(values[key], false))
+	}
+
+	propertiesFile := filepath.Join(targetDir, resolvedConfigFile)
+	if err = ioutil.WriteFile(propertiesFile, contents.Bytes(), 0644); err != nil {
+		format.Diagnose(string(err.Error()), os.Stderr, func() {
+			os.Exit(1)
+		})
+	}
+	fmt.Printf("Wrote %d properties from %d property sources to %s\n", len(keys), len(environment.PropertySources), propertiesFile)
+	fmt.Printf("Activate the '%s' profile (e.g. spring.profiles.active=cloud,%s) to use them\n", resolvedConfigProfile, resolvedConfigProfile)
+}
+
+// Escape a key or value for a java .properties file
+func escapeProperty(text string, isKey bool) string {
+	buffer := &bytes.Buffer{}
+	for i, r := range text {
+		switch {
+		case r == '\\':
+			buffer.WriteString(`\\`)
+		case r == '\n':
+			buffer.WriteString(`\n`)
+		case r == '\r':
+			buffer.WriteString(`\r`)
+		case r == '\t':
+			buffer.WriteString(`\t`)
+		case r == ' ' && (isKey || i == 0):
+			buffer.WriteString(`\ `)
+		case (r == '=' || r == ':') && isKey:
+			buffer.WriteRune('\\')
+			buffer.WriteRune(r)
+		case (r == '#' || r == '!') && i == 0:
+			buffer.WriteRune('\\')
+			buffer.WriteRune(r)
+		case r > 0xffff:
+			// \u escapes are UTF-16 code units
+			high, low := utf16.EncodeRune(r)
+			fmt.Fprintf(buffer, `\u%04x\u%04x`, high, low)
+		case r < 0x20 || r > 0x7e:
+			fmt.Fprintf(buffer, `\u%04x`, r)
+		default:
+			buffer.WriteRune(r)
+		}
+	}
+	return buffer.String()
+}
@@ -18,6 +18,7 @@
 
 import (
 	"code.cloudfoundry.org/cli/plugin"
+	"encoding/json"
 	"fmt"
 	"io"
 	"strings"
@@ -33,6 +34,23 @@ type DeployerOps interface {
 	FetchInetAddr(string) string
 	GetSshCode() string
 	GetGuid(string) string
+	GetQuotaLimits(string) []QuotaLimits
+	GetUserProvidedEnv(string) map[string]interface{}
+	GetRoutes(string) []string
+	SkipSslValidation() bool
+	GetNetworkPolicies(string) []NetworkPolicy
+	AddNetworkPolicies([]NetworkPolicy)
+	RemoveNetworkPolicies([]NetworkPolicy)
+	DeleteApp(string)
+}
+
+// QuotaLimits are the memory limits, in megabytes, of a space or org quota. A limit of -1 means unlimited.
+// MemoryUsed is what started applications already take out of the quota.
+type QuotaLimits struct {
+	Description         string
+	MemoryLimit         int64
+	InstanceMemoryLimit int64
+	MemoryUsed          int64
 }
 
 type Deployer struct {
@@ -92,24 +110,174 @@ func (d* Deployer) GetGuid(applicationName string) string {
 }
 
 func (d* Deployer) FetchInetAddr(applicationName string) string {
-	args:=[]string{"ssh",applicationName,"--force-pseudo-tty","-c",`/sbin/ifconfig eth0 | grep "inet addr" | sed 's/^[^:]*:\([^ ]*\).*$/\1/'`}
-//	args:=[]string{"spaces"}//ssh",applicationName,"-v","-c",`ls`}
-	fmt.Println("Fetching inet address")
-	//originalStdout := os.Stdout
-	//r,w,_:=os.Pipe()
-	//os.Stdout=w
-	fmt.Println("Capturing output")
-	x,err := d.cliConnection.CliCommand(args...)
-	//w.Close()
-	//out,_:=ioutil.ReadAll(r)
-	//os.Stdout=originalStdout
+	args:=[]string{"ssh",applicationName,"-c","echo $CF_INSTANCE_INTERNAL_IP"}
+	fmt.Println("Fetching inet address, command:\n  cf ssh",applicationName,"-c 'echo $CF_INSTANCE_INTERNAL_IP'")
+	x,err := d.cliConnection.CliCommandWithoutTerminalOutput(args...)
 	if err != nil {
 		d.errorFunc("Problem fetch inet addr", err)
 	}
-	fmt.Println("Fetched: ",strings.Join(x,""))//,string(out))
-	return strings.Join(x,"")
+	return strings.TrimSpace(strings.Join(x,""))
 }
 
 func (d *Deployer) Connect(cliConnection plugin.CliConnection) {
 	d.cliConnection = cliConnection
-}
\ No newline at end of file
+}
+
+// Collect the quotas that apply to the current space: the space quota (if there is one) and the org quota.
+// The memory used by applicationName isn't counted as used, pushing it again replaces it.
+func (d *Deployer) GetQuotaLimits(applicationName string) []QuotaLimits {
+	var quotas []QuotaLimits
+	space, err := d.cliConnection.GetCurrentSpace()
+	if err != nil {
+		d.errorFunc("Problem fetching current space", err)
+	}
+	spaceUsed, applicationUsed := d.spaceMemoryUsage(space.Guid, applicationName)
+	spaceModel, err := d.cliConnection.GetSpace(space.Name)
+	if err != nil {
+		d.errorFunc("Problem fetching space quota", err)
+	}
+	if spaceModel.SpaceQuota.Guid != "" {
+		quotas = append(quotas, QuotaLimits{
+			Description:         "space quota " + spaceModel.SpaceQuota.Name,
+			MemoryLimit:         spaceModel.SpaceQuota.MemoryLimit,
+			InstanceMemoryLimit: spaceModel.SpaceQuota.InstanceMemoryLimit,
+			MemoryUsed:          spaceUsed,
+		})
+	}
+	org, err := d.cliConnection.GetCurrentOrg()
+	if err != nil {
+		d.errorFunc("Problem fetching current org", err)
+	}
+	orgModel, err := d.cliConnection.GetOrg(org.Name)
+	if err != nil {
+		d.errorFunc("Problem fetching org quota", err)
+	}
+	orgUsage := &struct {
+		MemoryUsageInMb int64 `json:"memory_usage_in_mb"`
+	}{}
+	d.curl(orgUsage, "/v2/organizations/"+org.Guid+"/memory_usage")
+	quotas = append(quotas, QuotaLimits{
+		Description:         "org quota " + orgModel.QuotaDefinition.Name,
+		MemoryLimit:         orgModel.QuotaDefinition.MemoryLimit,
+		InstanceMemoryLimit: orgModel.QuotaDefinition.InstanceMemoryLimit,
+		MemoryUsed:          orgUsage.MemoryUsageInMb - applicationUsed,
+	})
+	return quotas
+}
+
+// The memory taken by the started applications in the space, other than applicationName, and by applicationName
+func (d *Deployer) spaceMemoryUsage(spaceGuid string, applicationName string) (int64, int64) {
+	summary := &struct {
+		Apps []struct {
+			Name      string `json:"name"`
+			Memory    int64  `json:"memory"`
+			Instances int64  `json:"instances"`
+			State     string `json:"state"`
+		} `json:"apps"`
+	}{}
+	d.curl(summary, "/v2/spaces/"+spaceGuid+"/summary")
+	var used, applicationUsed int64
+	for _, app := range summary.Apps {
+		if app.State != "STARTED" {
+			continue
+		}
+		if app.Name == applicationName {
+			applicationUsed = app.Memory * app.Instances
+		} else {
+			used += app.Memory * app.Instances
+		}
+	}
+	return used, applicationUsed
+}
+
+func (d *Deployer) GetUserProvidedEnv(applicationName string) map[string]interface{} {
+	app, err := d.cliConnection.GetApp(applicationName)
+	if err != nil {
+		d.errorFunc("Problem fetching application "+applicationName, err)
+	}
+	return app.EnvironmentVars
+}
+
+// The routes (host.domain/path) mapped to the application
+func (d *Deployer) GetRoutes(applicationName string) []string {
+	app, err := d.cliConnection.GetApp(applicationName)
+	if err != nil {
+		d.errorFunc("Problem fetching application "+applicationName, err)
+	}
+	var routes []string
+	for _, route := range app.Routes {
+		hostname := route.Domain.Name
+		if route.Host != "" {
+			hostname = route.Host + "." + hostname
+		}
+		routes = append(routes, hostname+route.Path)
+	}
+	return routes
+}
+
+// Whether the cf CLI is targeting an api with --skip-ssl-validation, service calls follow suit
+func (d *Deployer) SkipSslValidation() bool {
+	disabled, err := d.cliConnection.IsSSLDisabled()
+	return err == nil && disabled
+}
+
+func (d *Deployer) DeleteApp(applicationName string) {
+	args := []string{"delete", applicationName, "-f", "-r"}
+	if _, err := d.cliConnection.CliCommand(args...); err != nil {
+		d.errorFunc("Could not delete application", err)
+	}
+}
+
+const networkPoliciesPath = "/networking/v1/external/policies"
+
+type networkPolicies struct {
+	Policies []NetworkPolicy `json:"policies"`
+}
+
+// Policies where the application is either the source or the destination
+func (d *Deployer) GetNetworkPolicies(guid string) []NetworkPolicy {
+	policies := &networkPolicies{}
+	d.curl(policies, networkPoliciesPath+"?id="+guid)
+	return policies.Policies
+}
+
+func (d *Deployer) AddNetworkPolicies(policies []NetworkPolicy) {
+	d.postPolicies(networkPoliciesPath, policies)
+}
+
+func (d *Deployer) RemoveNetworkPolicies(policies []NetworkPolicy) {
+	d.postPolicies(networkPoliciesPath+"/delete", policies)
+}
+
+func (d *Deployer) postPolicies(path string, policies []NetworkPolicy) {
+	body, err := json.Marshal(networkPolicies{policies})
+	if err != nil {
+		d.errorFunc("Problem encoding network policies", err)
+	}
+	d.curl(nil, path, "-X", "POST", "-d", string(body))
+}
+
+// Make a request with 'cf curl', decoding the json response into result (if not nil). Errors
+// reported in the response body are treated as failures.
+func (d *Deployer) curl(result interface{}, path string, options ...string) {
+	output, err := d.cliConnection.CliCommandWithoutTerminalOutput(append([]string{"curl", path}, options...)...)
+	if err != nil {
+		d.errorFunc("Problem calling "+path, err)
+	}
+	response := strings.Join(output, "\n")
+	if strings.TrimSpace(response) == "" {
+		return
+	}
+	var failure struct {
+		Error       string `json:"error"`
+		Description string `json:"description"`
+	}
+	if json.Unmarshal([]byte(response), &failure); failure.Error != "" || failure.Description != "" {
+		d.errorFunc("Problem calling "+path, fmt.Errorf("%s %s", failure.Error, failure.Description))
+	}
+	if result != nil {
+		if err = json.Unmarshal([]byte(response), result); err != nil {
+			d.errorFunc("Problem parsing response from "+path, err)
+		}
+	}
+}
@@ -0,0 +1,127 @@
+/*
+ * Copyright (C) 2018-Present Pivotal Software, Inc. All rights reserved.
+ *
+ * This program and the accompanying materials are made available under
+ * the terms of the under the Apache License, Version 2.0 (the "License”);
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package main
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strings"
+
+	"github.com/aclement/tunnel-boot/format"
+	"github.com/aclement/tunnel-boot/scs"
+	"github.com/aclement/tunnel-boot/session"
+)
+
+// For an exclusive tunnel, mark the real instances of the service the tunnel application stands in
+// for as OUT_OF_SERVICE so that all the traffic comes down the tunnel. What was changed is recorded
+// in the session so it can be put back by tunnel-recover if the plugin doesn't get to do it itself.
+func (p *Plugin) takeRealInstancesOutOfService(applicationName string, s *session.Session, hooks *shutdownHooks) {
+	failed := func(message string) {
+		format.Diagnose(message, os.Stderr, func() {
+			os.Exit(1)
+		})
+	}
+	if len(s.OutOfService) != 0 {
+		hooks.run()
+		failed(fmt.Sprintf("An earlier tunnel session for %s left instances out of service. Run 'cf tunnel-recover %s' first.", applicationName, applicationName))
+	}
+
+	client := p.registryClient(applicationName)
+	springApplicationName := springApplicationNameFromEnvironment(p.deployer.GetUserProvidedEnv(applicationName), applicationName)
+	apps, err := client.Applications()
+	if err != nil {
+		failed(err.Error())
+	}
+	tunnelHosts := p.tunnelHosts(applicationName)
+
+	hooks.add(func() {
+		if err := restoreInstances(client, s, os.Stdout); err != nil {
+			log.Println(err)
+		}
+	})
+	for _, app := range apps {
+		if !strings.EqualFold(app.Name, springApplicationName) {
+			continue
+		}
+		for _, instance := range app.Instance {
+			if isTunnelInstance(instance, tunnelHosts) {
+				continue
+			}
+			// Record the instance before changing it, so tunnel-recover knows about it whatever happens next
+			s.OutOfService = append(s.OutOfService, session.RegistryInstance{App: app.Name, InstanceId: instance.InstanceId})
+			if err = s.Save(); err != nil {
+				s.OutOfService = s.OutOfService[:len(s.OutOfService)-1]
+				hooks.run()
+				failed(err.Error())
+			}
+			fmt.Println("Taking", instance.InstanceId, "out of service")
+			if err = client.SetStatus(app.Name, instance.InstanceId, scs.StatusOutOfService); err != nil {
+				s.OutOfService = s.OutOfService[:len(s.OutOfService)-1]
+				if saveErr := s.SaveOrRemove(); saveErr != nil {
+					log.Println(saveErr)
+				}
+				hooks.run()
+				failed(err.Error())
+			}
+		}
+	}
+	if len(s.OutOfService) == 0 {
+		fmt.Println("No other instances of", springApplicationName, "are registered, the tunnel already has all the traffic")
+	}
+}
+
+// Put the instances recorded in the session back in service. Instances that have since disappeared
+// from the registry are forgotten, any that fail are kept in the session for another attempt.
+func restoreInstances(client *scs.Client, s *session.Session, out io.Writer) error {
+	var remaining []session.RegistryInstance
+	var lastErr error
+	for _, instance := range s.OutOfService {
+		fmt.Fprintln(out, "Putting", instance.InstanceId, "back in service")
+		err := client.RemoveStatusOverride(instance.App, instance.InstanceId)
+		if err != nil && !scs.IsNotFound(err) {
+			remaining = append(remaining, instance)
+			lastErr = err
+		}
+	}
+	s.OutOfService = remaining
+	if err := s.SaveOrRemove(); err != nil {
+		return err
+	}
+	if len(remaining) == 0 {
+		return lastErr
+	}
+	return fmt.Errorf("Unable to put %d instances back in service, run 'cf tunnel-recover %s' to retry: %s", len(remaining), s.TunnelApp, lastErr)
+}
+
+// Recover from a start-tunnel that exited without restoring the real instances.
+func (p *Plugin) tunnelRecover(applicationName string) format.Action {
+	return func(progressWriter io.Writer) (string, error) {
+		s, err := session.Load(applicationName)
+		if err != nil {
+			return "", err
+		}
+		if s == nil || len(s.OutOfService) == 0 {
+			return "Nothing to recover for " + applicationName + "\n", nil
+		}
+		if err = restoreInstances(p.registryClient(applicationName), s, progressWriter); err != nil {
+			return "", err
+		}
+		return "", nil
+	}
+}
@@ -0,0 +1,366 @@
+/*
+ * Copyright (C) 2018-Present Pivotal Software, Inc. All rights reserved.
+ *
+ * This program and the accompanying materials are made available under
+ * the terms of the under the Apache License, Version 2.0 (the "License”);
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package main
+
+import (
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aclement/tunnel-boot/format"
+	"github.com/aclement/tunnel-boot/proxy"
+	"github.com/aclement/tunnel-boot/session"
+)
+
+// How many requests the inspector keeps
+const inspectorBufferSize = 100
+
+// Whether the start-tunnel options need requests to pass through the local HTTP proxy rather than
+// going straight from the tunnel to the local app
+func needsHttpProxy(flags map[string]string, listFlags map[string][]string) bool {
+	for _, name := range []string{"inspect", "record", "fallback-to", "split", "mirror", "inject-latency", "inject-errors", "fault-rules", "control",
+		"require-token", "basic-auth", "max-rps", "max-conns", "rewrite-rules", "local-tls", "target", "status", "health-port", "health-path"} {
+		if len(flags[name]) != 0 {
+			return true
+		}
+	}
+	return len(listFlags["allow-cidr"]) != 0
+}
+
+// Start the HTTP proxy that sits between the reverse tunnel and the local app, returns the port
+// the tunnel should forward to. With a developer the proxy also routes on the developer header.
+func (p *Plugin) startHttpProxy(localTarget *localTarget, developer string, flags map[string]string, listFlags map[string][]string, s *session.Session, monitor *tunnelMonitor, hooks *shutdownHooks) string {
+	failed := func(message string) {
+		hooks.run()
+		format.Diagnose(message, os.Stderr, func() {
+			os.Exit(1)
+		})
+	}
+	target := &url.URL{Scheme: "http", Host: localTarget.hostPort()}
+	transport := &http.Transport{
+		DialContext:         localTarget.dial,
+		DisableCompression:  true,
+		MaxIdleConnsPerHost: 16,
+		IdleConnTimeout:     90 * time.Second,
+	}
+	if flags["local-tls"] == "true" {
+		target.Scheme = "https"
+		transport.TLSClientConfig = localTlsConfig(flags, localTarget.hostName(), hooks, failed)
+		fmt.Println("Connecting to the local app over TLS at", localTarget)
+		// A health endpoint on the app's own port needs TLS too
+		if path := flags["health-path"]; len(path) != 0 && len(flags["health-port"]) == 0 {
+			endpoint := *target
+			endpoint.Path = "/" + strings.TrimPrefix(path, "/")
+			monitor.useHealthEndpoint(&endpoint, transport.Clone())
+		}
+	}
+
+	// Middlewares run in order, captures come first so that everything (even rejected requests) is seen.
+	// localMiddlewares only apply to requests going to the local app, not those the real app answers.
+	var middlewares, capture, localMiddlewares []proxy.Middleware
+	if wantsStatus(flags) {
+		monitor.requests = &proxy.RequestCounter{}
+		middlewares = append(middlewares, monitor.requests.Middleware())
+	}
+	var store *proxy.Store
+	if len(flags["inspect"]) != 0 || len(flags["record"]) != 0 {
+		store = proxy.NewStore(inspectorBufferSize)
+		capture = append(capture, proxy.Capture(store))
+		middlewares = append(middlewares, capture...)
+	}
+	if harFile := flags["record"]; len(harFile) != 0 {
+		// Captures are made before the guard removes the credentials, keep them out of the file
+		redact := listFlags["redact-header"]
+		var redactQuery []string
+		if flags["require-token"] == "true" {
+			redact = append(redact, proxy.TokenHeader)
+			redactQuery = append(redactQuery, proxy.TokenQueryParameter)
+		}
+		if len(flags["basic-auth"]) != 0 {
+			redact = append(redact, "Authorization")
+		}
+		har, err := proxy.NewHarWriter(harFile, redact, redactQuery)
+		if err != nil {
+			failed(fmt.Sprintf("Unable to create HAR file %s: %s", harFile, err))
+		}
+		store.OnComplete(har.Add)
+		hooks.add(func() {
+			if err := har.Close(); err != nil {
+				log.Println(err)
+			}
+		})
+		fmt.Println("Recording requests passing through the tunnel to", harFile)
+	}
+
+	// Runtime controls for the proxy are served on the --control port
+	control := http.NewServeMux()
+	var controls []string
+
+	guard := accessGuard(flags, listFlags, failed)
+	if guard != nil {
+		middlewares = append(middlewares, guard.Middleware())
+	}
+	// Limits protect the local app, requests answered by the real app (and its callers) aren't held back
+	if limiter := requestLimiter(flags, failed); limiter != nil {
+		localMiddlewares = append(localMiddlewares, limiter.Middleware())
+		control.HandleFunc("/stats", func(w http.ResponseWriter, r *http.Request) {
+			stats := map[string]interface{}{"limits": limiter.Stats()}
+			if guard != nil {
+				stats["rejected"] = guard.Rejected()
+			}
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(stats)
+		})
+		controls = append(controls, "/stats")
+		hooks.add(func() {
+			stats := limiter.Stats()
+			fmt.Printf("\nPassed %d requests to the local app (%d had to queue), shed %d over the rate limit and %d over the connection limit\n",
+				stats.Passed, stats.Queued, stats.ShedRateLimit, stats.ShedConnLimit)
+		})
+	}
+
+	if len(flags["inject-latency"]) != 0 || len(flags["inject-errors"]) != 0 || len(flags["fault-rules"]) != 0 {
+		injector, err := faultInjector(flags)
+		if err != nil {
+			failed(err.Error())
+		}
+		localMiddlewares = append(localMiddlewares, injector.Middleware())
+		control.Handle("/faults", injector.ControlHandler())
+		controls = append(controls, "/faults")
+	}
+
+	// Rewrites only apply on the way to the local app, the real one gets requests as they were sent
+	if file := flags["rewrite-rules"]; len(file) != 0 {
+		rewriter, err := loadRewriter(file)
+		if err != nil {
+			failed(err.Error())
+		}
+		localMiddlewares = append(localMiddlewares, rewriter.Middleware())
+		fmt.Println("Rewriting requests to the local app with the rules in", file)
+	}
+	local := proxy.Chain(proxy.NewForwarder(target, transport), localMiddlewares...)
+	app := local
+	if route := flags["fallback-to"]; len(route) != 0 {
+		real, realName := p.realAppForwarder(route, s.TunnelApp, failed)
+		app = &proxy.Fallback{
+			Local:          local,
+			Remote:         real,
+			RemoteName:     realName,
+			LocalAvailable: localTarget.listening,
+		}
+		fmt.Println("Requests will be served by", realName, "whenever the local app is not accepting connections")
+	}
+	if len(flags["split"]) != 0 || flags["mirror"] == "true" {
+		if len(flags["real-route"]) == 0 {
+			failed("--split and --mirror need the real application's route, supply it with --real-route")
+		}
+		real, realName := p.realAppForwarder(flags["real-route"], s.TunnelApp, failed)
+		if flags["mirror"] == "true" {
+			if len(flags["split"]) != 0 {
+				failed("Use either --split or --mirror, not both")
+			}
+			// Copies are captured separately, so what the local app made of them can be inspected
+			shadowMiddlewares := append(append([]proxy.Middleware{}, capture...), proxy.ServedBy(proxy.ServedByMirror))
+			app = &proxy.Mirror{Primary: real, Shadow: proxy.Chain(local, shadowMiddlewares...)}
+			fmt.Println("Callers are answered by", realName, "and a copy of each request is sent to the local app")
+		} else {
+			percent, err := strconv.Atoi(flags["split"])
+			if err != nil || percent < 0 || percent > 100 {
+				failed(fmt.Sprintf("Invalid split '%s': must be a percentage from 0 to 100", flags["split"]))
+			}
+			app = &proxy.Split{Local: app, Remote: real, Percent: percent}
+			fmt.Printf("%d%% of requests are sent to the local app, the rest to %s\n", percent, realName)
+		}
+	}
+	if len(developer) != 0 {
+		app = p.developerRouter(developer, s.TunnelApp, app, flags["real-route"], failed)
+		if flags["exclusive"] != "true" {
+			fmt.Println("Without --exclusive the real instances also get requests, and answer them whoever they are meant for")
+		}
+	}
+	handler := proxy.Chain(app, middlewares...)
+
+	if inspectPort := flags["inspect"]; len(inspectPort) != 0 {
+		// Replays go through the same chain, so they are captured and reach the app the same way
+		serveLocally(inspectPort, "request inspector", proxy.NewInspector(store, handler), hooks)
+		s.Inspector = "127.0.0.1:" + inspectPort
+		fmt.Printf("Inspect requests passing through the tunnel at http://localhost:%s/ (JSON at /api/requests)\n", inspectPort)
+		fmt.Printf("Replay one with: cf tunnel-replay %s REQUEST_ID\n", s.TunnelApp)
+	}
+
+	if len(controls) != 0 || len(flags["control"]) != 0 {
+		controlPort := flags["control"]
+		if len(controlPort) == 0 {
+			controlPort = "0"
+		}
+		controlPort = serveLocally(controlPort, "control endpoint", control, hooks)
+		for _, path := range controls {
+			fmt.Printf("Control endpoint: http://localhost:%s%s\n", controlPort, path)
+		}
+	}
+
+	return serveLocally("0", "tunnel HTTP proxy", handler, hooks)
+}
+
+// Restrict who can reach the local app through the tunnel, returns nil if anybody may.
+func accessGuard(flags map[string]string, listFlags map[string][]string, failed func(string)) *proxy.Guard {
+	guard := &proxy.Guard{}
+	if flags["require-token"] == "true" {
+		token, err := proxy.NewToken()
+		if err != nil {
+			failed(fmt.Sprintf("Unable to generate a session token: %s", err))
+		}
+		guard.Token = token
+		fmt.Println("Requests must carry the session token, share it with teammates who should get through:")
+		fmt.Printf("  %s: %s\n", proxy.TokenHeader, token)
+		fmt.Printf("  (or add ?%s=%s to the URL)\n", proxy.TokenQueryParameter, token)
+	}
+	if credentials := flags["basic-auth"]; len(credentials) != 0 {
+		userPassword := strings.SplitN(credentials, ":", 2)
+		if len(userPassword) != 2 || len(userPassword[0]) == 0 {
+			failed(fmt.Sprintf("Invalid basic auth '%s', expected user:password", credentials))
+		}
+		guard.Username, guard.Password = userPassword[0], userPassword[1]
+		fmt.Println("Requests must carry basic auth credentials for user", guard.Username)
+	}
+	if cidrs := listFlags["allow-cidr"]; len(cidrs) != 0 {
+		networks, err := proxy.ParseNetworks(cidrs)
+		if err != nil {
+			failed(fmt.Sprintf("Invalid --allow-cidr: %s", err))
+		}
+		guard.AllowedNetworks = networks
+		guard.TrustedProxies = 1
+		if value, ok := flags["trusted-proxies"]; ok {
+			if guard.TrustedProxies, _ = strconv.Atoi(value); guard.TrustedProxies < 1 {
+				failed(fmt.Sprintf("Invalid trusted-proxies '%s': must be at least 1", value))
+			}
+		}
+		fmt.Println("Only requests from", strings.Join(cidrs, ", "), "are let through")
+	}
+	if len(guard.Token) == 0 && len(guard.Username) == 0 && len(guard.AllowedNetworks) == 0 {
+		return nil
+	}
+	return guard
+}
+
+func loadRewriter(file string) (*proxy.Rewriter, error) {
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to read rewrite rules: %s", err)
+	}
+	rules := proxy.RewriteRules{}
+	if err = json.Unmarshal(data, &rules); err != nil {
+		return nil, fmt.Errorf("Unable to parse rewrite rules file %s: %s", file, err)
+	}
+	rewriter, err := proxy.NewRewriter(rules)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid rewrite rules in %s: %s", file, err)
+	}
+	return rewriter, nil
+}
+
+// How long a request waits for one of the --max-conns slots before it is turned away
+const limiterQueueTimeout = 5 * time.Second
+
+// Limit the rate and concurrency of requests reaching the local app, returns nil if unlimited.
+func requestLimiter(flags map[string]string, failed func(string)) *proxy.Limiter {
+	limiter := &proxy.Limiter{QueueTimeout: limiterQueueTimeout}
+	for name, limit := range map[string]*int{"max-rps": &limiter.MaxRps, "max-conns": &limiter.MaxConns} {
+		if value, ok := flags[name]; ok {
+			*limit, _ = strconv.Atoi(value)
+			if *limit < 1 {
+				failed(fmt.Sprintf("Invalid %s '%s': must be at least 1", name, value))
+			}
+		}
+	}
+	if limiter.MaxRps == 0 && limiter.MaxConns == 0 {
+		return nil
+	}
+	if limiter.MaxRps != 0 {
+		fmt.Printf("Requests above %d a second are rejected with 429 Too Many Requests\n", limiter.MaxRps)
+	}
+	if limiter.MaxConns != 0 {
+		fmt.Printf("At most %d requests are passed to the local app at once, others wait up to %s and are then rejected with 503 Service Unavailable\n", limiter.MaxConns, limiterQueueTimeout)
+	}
+	return limiter
+}
+
+// Fault rules come from the --fault-rules file, with a catch all rule for --inject-latency/--inject-errors
+// after them.
+func faultInjector(flags map[string]string) (*proxy.FaultInjector, error) {
+	rules := &proxy.FaultRules{}
+	if file := flags["fault-rules"]; len(file) != 0 {
+		data, err := ioutil.ReadFile(file)
+		if err != nil {
+			return nil, fmt.Errorf("Unable to read fault rules: %s", err)
+		}
+		if err = json.Unmarshal(data, rules); err != nil {
+			return nil, fmt.Errorf("Unable to parse fault rules file %s: %s", file, err)
+		}
+	}
+	if len(flags["inject-latency"]) != 0 || len(flags["inject-errors"]) != 0 {
+		rules.Rules = append(rules.Rules, proxy.FaultRule{Latency: flags["inject-latency"], Errors: flags["inject-errors"]})
+	}
+	injector := &proxy.FaultInjector{}
+	if err := injector.SetRules(rules.Rules); err != nil {
+		return nil, fmt.Errorf("Invalid fault rules: %s", err)
+	}
+	for _, rule := range injector.Rules() {
+		data, _ := json.Marshal(rule)
+		fmt.Println("Injecting faults:", string(data))
+	}
+	return injector, nil
+}
+
+// A forwarder to the real (deployed) app through its route, returns it with the route's URL
+func (p *Plugin) realAppForwarder(route string, tunnelApp string, failed func(string)) (http.Handler, string) {
+	forwarder, name, err := routeForwarder(route, p.tunnelHosts(tunnelApp), p.deployer.SkipSslValidation())
+	if err != nil {
+		failed(err.Error())
+	}
+	return forwarder, name
+}
+
+// A forwarder to an application through its route, routes of the tunnel application itself (tunnelHosts)
+// are refused.
+func routeForwarder(route string, tunnelHosts map[string]bool, skipSslValidation bool) (http.Handler, string, error) {
+	if !strings.Contains(route, "://") {
+		route = "https://" + route
+	}
+	target, err := url.Parse(route)
+	if err != nil || (target.Scheme != "http" && target.Scheme != "https") || len(target.Host) == 0 {
+		return nil, "", fmt.Errorf("Invalid route '%s', expected a route such as myapp.cfapps.io or https://myapp.cfapps.io", route)
+	}
+	// Sending requests to the tunnel's own route would just send them round in a circle
+	if tunnelHosts[target.Hostname()] {
+		return nil, "", fmt.Errorf("The route %s belongs to the tunnel application, use the real application's route", target.Host)
+	}
+	transport := &http.Transport{
+		Proxy:           http.ProxyFromEnvironment,
+		TLSClientConfig: &tls.Config{InsecureSkipVerify: skipSslValidation},
+		IdleConnTimeout: 90 * time.Second,
+	}
+	return proxy.NewRouteForwarder(target, transport), target.String(), nil
+}
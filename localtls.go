@@ -0,0 +1,89 @@
+/*
+ * Copyright (C) 2018-Present Pivotal Software, Inc. All rights reserved.
+ *
+ * This program and the accompanying materials are made available under
+ * the terms of the under the Apache License, Version 2.0 (the "License”);
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"os"
+
+	"github.com/aclement/tunnel-boot/proxy"
+)
+
+// The TLS settings for connecting to a local app that only listens on https, optionally presenting a client
+// certificate. With --generate-certs a throwaway CA and certificates are created for the session (and removed
+// when it ends) and the settings for the local app to use them are printed.
+func localTlsConfig(flags map[string]string, serverName string, hooks *shutdownHooks, failed func(string)) *tls.Config {
+	caFile, certFile, keyFile := flags["ca"], flags["cert"], flags["key"]
+	if flags["generate-certs"] == "true" {
+		if len(caFile) != 0 || len(certFile) != 0 || len(keyFile) != 0 {
+			failed("Use either --generate-certs or --ca/--cert/--key, not both")
+		}
+		dir, err := ioutil.TempDir("", "tunnel-boot-certs")
+		if err != nil {
+			failed(err.Error())
+		}
+		hooks.add(func() {
+			os.RemoveAll(dir)
+		})
+		certificates, err := proxy.GenerateCertificates(dir, serverName)
+		if err != nil {
+			failed(fmt.Sprintf("Unable to generate certificates: %s", err))
+		}
+		caFile, certFile, keyFile = certificates.CaFile, certificates.ClientCertFile, certificates.ClientKeyFile
+		printGeneratedCertificates(certificates)
+	}
+	if (len(certFile) == 0) != (len(keyFile) == 0) {
+		failed("A client certificate needs both --cert and --key")
+	}
+
+	config := &tls.Config{ServerName: serverName}
+	if len(caFile) != 0 {
+		data, err := ioutil.ReadFile(caFile)
+		if err != nil {
+			failed(fmt.Sprintf("Unable to read CA certificate: %s", err))
+		}
+		config.RootCAs = x509.NewCertPool()
+		if !config.RootCAs.AppendCertsFromPEM(data) {
+			failed(fmt.Sprintf("No PEM certificates found in %s", caFile))
+		}
+	} else {
+		fmt.Println("The local app's certificate will not be verified, supply its CA with --ca to check it")
+		config.InsecureSkipVerify = true
+	}
+	if len(certFile) != 0 {
+		certificate, err := tls.LoadX509KeyPair(certFile, keyFile)
+		if err != nil {
+			failed(fmt.Sprintf("Unable to load the client certificate: %s", err))
+		}
+		config.Certificates = []tls.Certificate{certificate}
+	}
+	return config
+}
+
+func printGeneratedCertificates(certificates *proxy.Certificates) {
+	fmt.Println("Generated certificates for this session (removed when the tunnel stops):")
+	fmt.Println("  CA:                  ", certificates.CaFile)
+	fmt.Println("  local app certificate:", certificates.ServerCertFile)
+	fmt.Println("  local app key:        ", certificates.ServerKeyFile)
+	fmt.Println("  tunnel client cert:   ", certificates.ClientCertFile)
+	fmt.Println("Launch a Spring Boot app (2.7+) with:")
+	fmt.Printf("  --server.ssl.certificate=%s --server.ssl.certificate-private-key=%s --server.ssl.trust-certificate=%s --server.ssl.client-auth=need\n",
+		certificates.ServerCertFile, certificates.ServerKeyFile, certificates.CaFile)
+}
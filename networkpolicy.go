@@ -0,0 +1,91 @@
+/*
+ * Copyright (C) 2018-Present Pivotal Software, Inc. All rights reserved.
+ *
+ * This program and the accompanying materials are made available under
+ * the terms of the under the Apache License, Version 2.0 (the "License”);
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package main
+
+import (
+	"fmt"
+)
+
+// Container to container network policies, as represented by the policy server external API
+// (/networking/v1/external/policies)
+type NetworkPolicy struct {
+	Source      PolicySource      `json:"source"`
+	Destination PolicyDestination `json:"destination"`
+}
+
+type PolicySource struct {
+	Id string `json:"id"`
+}
+
+type PolicyDestination struct {
+	Id       string      `json:"id"`
+	Protocol string      `json:"protocol"`
+	Ports    PolicyPorts `json:"ports"`
+}
+
+type PolicyPorts struct {
+	Start int `json:"start"`
+	End   int `json:"end"`
+}
+
+func (np NetworkPolicy) String() string {
+	ports := fmt.Sprint(np.Destination.Ports.Start)
+	if np.Destination.Ports.End != np.Destination.Ports.Start {
+		ports += fmt.Sprintf("-%d", np.Destination.Ports.End)
+	}
+	return fmt.Sprintf("%s -> %s %s:%s", np.Source.Id, np.Destination.Id, np.Destination.Protocol, ports)
+}
+
+// Give the tunnel application the same inbound and outbound policies as the real application, so that
+// internal callers can reach the tunnel (and so the developer's machine) exactly as they reach the real app.
+func (p *Plugin) copyNetworkPolicies(realApplicationName string, tunnelApplicationName string) {
+	realGuid := p.deployer.GetGuid(realApplicationName)
+	tunnelGuid := p.deployer.GetGuid(tunnelApplicationName)
+
+	var copies []NetworkPolicy
+	for _, policy := range p.deployer.GetNetworkPolicies(realGuid) {
+		tunnelPolicy := policy
+		if policy.Source.Id == realGuid {
+			tunnelPolicy.Source.Id = tunnelGuid
+		}
+		if policy.Destination.Id == realGuid {
+			tunnelPolicy.Destination.Id = tunnelGuid
+		}
+		copies = append(copies, tunnelPolicy)
+	}
+	if len(copies) == 0 {
+		fmt.Println("Application", realApplicationName, "has no network policies to copy")
+		return
+	}
+	for _, policy := range copies {
+		fmt.Println("Adding network policy", policy)
+	}
+	p.deployer.AddNetworkPolicies(copies)
+}
+
+// Remove every network policy involving the tunnel application
+func (p *Plugin) removeNetworkPolicies(tunnelApplicationName string) {
+	guid := p.deployer.GetGuid(tunnelApplicationName)
+	policies := p.deployer.GetNetworkPolicies(guid)
+	if len(policies) == 0 {
+		return
+	}
+	for _, policy := range policies {
+		fmt.Println("Removing network policy", policy)
+	}
+	p.deployer.RemoveNetworkPolicies(policies)
+}
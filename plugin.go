@@ -18,13 +18,9 @@ package main
 
 import (
 	"fmt"
-	"io"
 	"os"
 	"path/filepath"
 
-	"os/signal"
-	"syscall"
-
 	"code.cloudfoundry.org/cli/cf/flags"
 	"code.cloudfoundry.org/cli/plugin"
 
@@ -39,8 +35,6 @@ import (
 
 	"bytes"
 
-	"os/exec"
-
 	"github.com/dynport/gossh"
 )
 
@@ -63,11 +57,13 @@ func MakeLogger(prefix string) gossh.Writer {
 func (p *Plugin) Run(cliConnection plugin.CliConnection, args []string) {
 	var positionalArgs []string
 	var flags map[string]string
+	var listFlags map[string][]string
 	var err error
 
+	p.cliConnection = cliConnection
 	p.deployer.Connect(cliConnection)
 
-	flags, positionalArgs, err = parseFlagsAndOptions(args)
+	flags, listFlags, positionalArgs, err = parseFlagsAndOptions(args)
 
 	if err != nil {
 		format.Diagnose(string(err.Error()), os.Stderr, func() {
@@ -86,19 +82,59 @@ func (p *Plugin) Run(cliConnection plugin.CliConnection, args []string) {
 		if len(springApplicationName) == 0 {
 			springApplicationName = cfApplicationName
 		}
+		settings, err := loadTunnelAppSettings(flags, listFlags)
+		if err == nil {
+			err = settings.checkQuotas(p.deployer.GetQuotaLimits(cfApplicationName))
+		}
+		if err != nil {
+			format.Diagnose(string(err.Error()), os.Stderr, func() {
+				os.Exit(1)
+			})
+		}
 		fmt.Println("Pushing tunnel hosting application:", cfApplicationName)
 		tempDir := getTempDir()
-		shadowAppPath := unpackTunnelApplication(tempDir)
-		manifestPath := unpackManifestTemplateAndFillIn(tempDir, cfApplicationName, springApplicationName, flags["services"], shadowAppPath)
+		var shadowAppPath string
+		if len(flags["tunnel-app"]) != 0 {
+			shadowAppPath, err = resolveTunnelApplication(tempDir, flags["tunnel-app"], p.deployer.SkipSslValidation())
+		} else {
+			shadowAppPath = unpackTunnelApplication(tempDir)
+		}
+		var checksum string
+		if err == nil {
+			checksum, err = checksumArtifact(shadowAppPath)
+		}
+		if err != nil {
+			format.Diagnose(string(err.Error()), os.Stderr, func() {
+				os.Exit(1)
+			})
+		}
+		fmt.Println("Tunnel application sha256:", checksum)
+		manifestPath := unpackManifestTemplateAndFillIn(tempDir, cfApplicationName, springApplicationName, flags["services"], shadowAppPath, checksum, settings)
 		p.deployer.PushApp(cfApplicationName, manifestPath)
+		if len(flags["copy-network-policies-from"]) != 0 {
+			p.copyNetworkPolicies(flags["copy-network-policies-from"], cfApplicationName)
+		}
+		if len(settings.Developer) != 0 {
+			printDeveloperRouting(settings.Developer)
+		}
+
+	case "delete-tunnel-app":
+		applicationName := getApplicationName(argsConsumer)
+		p.removeNetworkPolicies(applicationName)
+		p.deployer.DeleteApp(applicationName)
 
 	case "get-local-env":
 		applicationName := getApplicationName(argsConsumer)
 		// fmt.Println("Fetching env vars for tunnel application:",applicationName)
 		varData := p.deployer.GetEnvVars(applicationName)
 		vars := processVars(varData)
+		profiles := "cloud"
+		if flags["resolve-config"] == "true" {
+			p.resolveConfig(applicationName, flags["target-dir"])
+			profiles = "cloud," + resolvedConfigProfile
+		}
 		if flags["create-eclipse-launch-config"] == "true" {
-			produceEclipseLaunchConfiguration(flags["target-dir"], flags["project"], flags["application-main"], flags["port"], vars)
+			produceEclipseLaunchConfiguration(flags["target-dir"], flags["project"], flags["application-main"], flags["port"], profiles, vars)
 		} else {
 			fmt.Println("Variables for use in your IDE:")
 			for varName, varValue := range vars {
@@ -112,59 +148,59 @@ func (p *Plugin) Run(cliConnection plugin.CliConnection, args []string) {
 
 	case "start-tunnel":
 		applicationName := getApplicationName(argsConsumer)
-		localPort := getLocalPort(argsConsumer)
-		code := p.deployer.GetSshCode()
-		guid := p.deployer.GetGuid(applicationName)
-
-		fmt.Println("The guid for the app is " + guid)
-		fmt.Println("The one time ssh code is " + code)
-
-		sigs := make(chan os.Signal, 1)
-
-		signal.Notify(sigs, syscall.SIGINT, syscall.SIGTERM)
-
-		_, err := exec.LookPath("sshpass")
-		if err != nil {
-			fmt.Printf("Unable to find sshpass, please install it and re-run or execute the following ssh command manually to start the tunnel\n")
-			fmt.Println("  ssh -o UserKnownHostsFile=/dev/null -o StrictHostKeyChecking=no -N -p 2222 cf:" + guid + "/0@ssh.run.pivotal.io -R *:8080:localhost:" + localPort)
-			fmt.Printf("(supply the sshcode printed above, or create a new one via: cf ssh-code)")
-			os.Exit(1)
+		var localPort string
+		if _, ok := flags["target"]; !ok {
+			localPort = getLocalPort(argsConsumer)
 		}
+		p.startTunnel(applicationName, localPort, flags, listFlags)
 
-		fmt.Println("Connecting tunnel, command:\n  sshpass -p " + code + " ssh -o UserKnownHostsFile=/dev/null -o StrictHostKeyChecking=no -N -p 2222 cf:" + guid + "/0@ssh.run.pivotal.io -R *:8080:localhost:" + localPort)
-		sshCmd := exec.Command("sshpass", "-p", code, "ssh", "-o", "UserKnownHostsFile=/dev/null", "-o", "StrictHostKeyChecking=no", "-N", "-p", "2222", "cf:"+guid+"/0@ssh.run.pivotal.io", "-R", "*:8080:localhost:"+localPort)
-
-		stdoutIn, _ := sshCmd.StdoutPipe()
-		stderrIn, _ := sshCmd.StderrPipe()
+	case "config-server-encrypt":
+		applicationName := getApplicationName(argsConsumer)
+		value := getValueOrFile(argsConsumer, flags["file"], "value to encrypt")
+		format.RunActionQuietly(cliConnection, p.configServerEncrypt(applicationName, value), os.Stdout, func() {
+			os.Exit(1)
+		})
 
-		var stdoutBuf, stderrBuf bytes.Buffer
-		var errStdout, errStderr error
+	case "config-server-decrypt":
+		applicationName := getApplicationName(argsConsumer)
+		value := getValueOrFile(argsConsumer, flags["file"], "value to decrypt")
+		format.RunActionQuietly(cliConnection, p.configServerDecrypt(applicationName, value), os.Stdout, func() {
+			os.Exit(1)
+		})
 
-		stdout := io.MultiWriter(os.Stdout, &stdoutBuf)
-		stderr := io.MultiWriter(os.Stderr, &stderrBuf)
+	case "tunnel-token":
+		applicationName := getApplicationName(argsConsumer)
+		service := argsConsumer.Consume(2, "service")
+		format.RunActionQuietly(cliConnection, p.tunnelToken(applicationName, service), os.Stdout, func() {
+			os.Exit(1)
+		})
 
-		err = sshCmd.Start()
-		if err != nil {
-			log.Fatalf("sshCmd.Start() failed with %s\n", err)
-		}
+	case "tunnel-curl":
+		applicationName := getApplicationName(argsConsumer)
+		service := argsConsumer.Consume(2, "service")
+		path := argsConsumer.Consume(3, "path")
+		format.RunActionQuietly(cliConnection, p.tunnelCurl(applicationName, service, path, flags, listFlags["header"]), os.Stdout, func() {
+			os.Exit(1)
+		})
 
-		go func() {
-			_, errStdout = io.Copy(stdout, stdoutIn)
-		}()
+	case "tunnel-recover":
+		applicationName := getApplicationName(argsConsumer)
+		format.RunAction(cliConnection, "Restoring registry instances taken out of service by a tunnel to "+applicationName, p.tunnelRecover(applicationName), os.Stdout, func() {
+			os.Exit(1)
+		})
 
-		go func() {
-			_, errStderr = io.Copy(stderr, stderrIn)
-		}()
+	case "tunnel-replay":
+		applicationName := getApplicationName(argsConsumer)
+		requestId := argsConsumer.Consume(2, "request id")
+		format.RunActionQuietly(cliConnection, p.tunnelReplay(applicationName, requestId, flags), os.Stdout, func() {
+			os.Exit(1)
+		})
 
-		err = sshCmd.Wait()
-		if err != nil {
-			log.Fatalf("sshCmd.Start() failed with '%s'\n", err)
-		}
-		if errStdout != nil || errStderr != nil {
-			log.Fatal("failed to capture stdout or stderr\n")
-		}
-		outStr, errStr := string(stdoutBuf.Bytes()), string(stderrBuf.Bytes())
-		fmt.Printf("\nout:\n%s\nerr:\n%s\n", outStr, errStr)
+	case "service-registry-list":
+		applicationName := getApplicationName(argsConsumer)
+		format.RunAction(cliConnection, "Listing service registry of "+applicationName, p.serviceRegistryList(applicationName), os.Stdout, func() {
+			os.Exit(1)
+		})
 	}
 }
 
@@ -185,11 +221,33 @@ func (p *Plugin) GetMetadata() plugin.PluginMetadata {
 				UsageDetails: plugin.Usage{
 					Usage: `   cf push-tunnel-app CF_APPLICATION_NAME`,
 					Options: map[string]string{
-						"--services/--s <servicesList>": "comma separated list of services to bind to",
-						"--spring-app-name <appName>":   "spring application name used when registering with service registry",
+						"--services/--s <servicesList>":      "comma separated list of services to bind to",
+						"--spring-app-name <appName>":        "spring application name used when registering with service registry",
+						"--tunnel-app <pathOrUrl>":           "jar, zip or directory (or a URL to a jar or zip) to push instead of the built-in tunnel application",
+						"--memory <size>":                    "memory limit for the tunnel application (e.g. 512M, 1G), defaults to 1024M",
+						"--disk <size>":                      "disk limit for the tunnel application (e.g. 512M, 1G)",
+						"--instances <n>":                    "number of instances of the tunnel application, defaults to 1",
+						"--stack <stackName>":                "stack to use for the tunnel application",
+						"--buildpack <buildpack>":            "buildpack to use for the tunnel application",
+						"--env <KEY=VALUE>":                  "environment variable to set on the tunnel application, may be repeated",
+						"--health-check-type <type>":         "health check type: port, process, http or none, defaults to process",
+						"--health-check-endpoint <path>":     "endpoint for the 'http' health check type",
+						"--registry-url <url>":               "service registry URL the tunnel application should register with (eureka.client.serviceUrl.defaultZone)",
+						"--metadata <key=value>":             "eureka instance metadata for the tunnel application, may be repeated. Implies needsExplicitRouting",
+						"--developer <name>":                 "tag the tunnel with this developer name, start-tunnel then routes requests by their X-Tunnel-Developer header",
+						"--copy-network-policies-from <app>": "give the tunnel application the same inbound and outbound network policies as this application",
+						"--config-file <file>":               "JSON file supplying any of the settings above, flags take precedence",
 					},
 				},
 			},
+			{
+				Name:     "delete-tunnel-app",
+				HelpText: "Delete a tunnel application, its routes and any network policies involving it",
+				Alias:    "dta",
+				UsageDetails: plugin.Usage{
+					Usage: `   cf delete-tunnel-app CF_APPLICATION_NAME`,
+				},
+			},
 			{
 				Name:     "get-local-env",
 				HelpText: "Retrieve environment vars to specify for local app launching",
@@ -202,6 +260,7 @@ func (p *Plugin) GetMetadata() plugin.PluginMetadata {
 						"--application-main <fqAppClassName>": "for eclipse config creation, the application main class",
 						"--port <nnnn>":                       "for eclipse config creation, the local port number being tunneled to",
 						"--target-dir <folder>":               "for eclipse config creation, target directory in which to create .launch file",
+						"--resolve-config":                    "write the properties the bound config server serves for the cloud profile to application-tunnel.properties (in the target directory if specified)",
 					},
 				},
 			},
@@ -210,7 +269,110 @@ func (p *Plugin) GetMetadata() plugin.PluginMetadata {
 				HelpText: "Create the ssh tunnel to connect a local port to the CF application",
 				Alias:    "stun",
 				UsageDetails: plugin.Usage{
-					Usage: `   cf start-tunnel CF_APPLICATION_NAME LOCAL_PORT`,
+					Usage: `   cf start-tunnel CF_APPLICATION_NAME LOCAL_PORT
+   cf start-tunnel CF_APPLICATION_NAME --target (HOST:PORT | unix:SOCKET_PATH)`,
+					Options: map[string]string{
+						"--register":                     "register the tunnel application in the bound service registry directly, deregistering when the tunnel stops",
+						"--exclusive":                    "take the real application's instances OUT_OF_SERVICE in the service registry while the tunnel is up",
+						"--internal-proxy <port>":        "run a local HTTP proxy on this port through which the local app can reach *.apps.internal routes",
+						"--socks <port>":                 "run a SOCKS5 proxy on this port whose connections are made from inside the tunnel application",
+						"--inspect <port>":               "pass requests through a local HTTP proxy and browse them, with headers, bodies and timings, on this port",
+						"--record <file>":                "write the requests and responses passing through the tunnel to this file in HAR format",
+						"--redact-header <name>":         "replace the value of this header in the HAR file, may be repeated",
+						"--fallback-to <route>":          "send requests to the real application's route while the local app is not accepting connections",
+						"--real-route <route>":           "the real application's route, used by --split, --mirror and developer routing",
+						"--split <percent>":              "send this percentage of requests to the local app and the rest to the real application",
+						"--mirror":                       "answer callers from the real application and send a copy of each request to the local app",
+						"--inject-latency <time>":        "delay every request by this long (e.g. 500ms) before it reaches the local app",
+						"--inject-errors <rate>":         "fail this percentage of requests (e.g. 5%) with a 503 instead of passing them to the local app",
+						"--fault-rules <file>":           "JSON file of fault rules matching on method and path",
+						"--control <port>":               "serve the runtime control endpoints (e.g. /faults, /stats) on this port",
+						"--require-token":                "only let through requests carrying the generated session token",
+						"--allow-cidr <range>":           "only let through requests from callers in this address range, may be repeated",
+						"--trusted-proxies <n>":          "with --allow-cidr, how many proxies in front of the tunnel add to X-Forwarded-For (default 1, the cf router)",
+						"--basic-auth <user:pass>":       "only let through requests with these basic auth credentials",
+						"--max-rps <n>":                  "reject requests above this many a second with 429 Too Many Requests",
+						"--max-conns <n>":                "pass at most this many requests at once to the local app, queueing briefly then rejecting with 503",
+						"--rewrite-rules <file>":         "JSON file of header, path prefix and Location rewrites applied between callers and the local app",
+						"--local-tls":                    "connect to the local app over TLS",
+						"--ca <file>":                    "with --local-tls, verify the local app's certificate against this PEM CA",
+						"--cert <file>":                  "with --local-tls, present this PEM client certificate to the local app",
+						"--key <file>":                   "with --local-tls, the PEM private key of the client certificate",
+						"--generate-certs":               "with --local-tls, generate a throwaway CA, local app and client certificate for the session",
+						"--target <host:port|unix:path>": "deliver requests to this address or unix socket instead of a local port, e.g. an app in a container",
+						"--status":                       "keep a live status line showing the tunnel and local app state and request counts",
+						"--health-port <port>":           "probe the local app's health on this port rather than the one requests go to (implies --status)",
+						"--health-path <path>":           "probe the local app with a GET of this path, e.g. /actuator/health, rather than just connecting (implies --status)",
+					},
+				},
+			},
+			{
+				Name:     "config-server-encrypt",
+				HelpText: "Encrypt a value using the config server bound to a tunnel application",
+				Alias:    "cse",
+				UsageDetails: plugin.Usage{
+					Usage: `   cf config-server-encrypt CF_APPLICATION_NAME VALUE_TO_ENCRYPT | --file FILE`,
+					Options: map[string]string{
+						"--file/-f <file>": cli.FileNameUsage,
+					},
+				},
+			},
+			{
+				Name:     "config-server-decrypt",
+				HelpText: "Decrypt a {cipher} value using the config server bound to a tunnel application",
+				Alias:    "csd",
+				UsageDetails: plugin.Usage{
+					Usage: `   cf config-server-decrypt CF_APPLICATION_NAME VALUE_TO_DECRYPT | --file FILE`,
+					Options: map[string]string{
+						"--file/-f <file>": "A text file whose contents are to be decrypted. Cannot be used with VALUE_TO_DECRYPT parameter.",
+					},
+				},
+			},
+			{
+				Name:     "tunnel-token",
+				HelpText: "Print an OAuth access token for a Spring Cloud Services instance bound to a tunnel application",
+				UsageDetails: plugin.Usage{
+					Usage: "   cf tunnel-token CF_APPLICATION_NAME SERVICE\n\n" +
+						"   SERVICE is a service instance name or label, or one of service-registry, config-server",
+				},
+			},
+			{
+				Name:     "tunnel-curl",
+				HelpText: "Make an authenticated request to a Spring Cloud Services instance bound to a tunnel application",
+				UsageDetails: plugin.Usage{
+					Usage: "   cf tunnel-curl CF_APPLICATION_NAME SERVICE PATH\n\n" +
+						"   SERVICE is a service instance name or label, or one of service-registry, config-server",
+					Options: map[string]string{
+						"-X <method>":        "HTTP method, defaults to GET (or POST if data is supplied)",
+						"-d <data|@file>":    "request body, or @file to read it from a file",
+						"-H <'Name: value'>": "request header, may be repeated",
+					},
+				},
+			},
+			{
+				Name:     "tunnel-recover",
+				HelpText: "Put back in service any registry instances left OUT_OF_SERVICE by an exclusive tunnel that did not shut down cleanly",
+				UsageDetails: plugin.Usage{
+					Usage: `   cf tunnel-recover CF_APPLICATION_NAME`,
+				},
+			},
+			{
+				Name:     "tunnel-replay",
+				HelpText: "Send a request captured by start-tunnel --inspect to the local app again",
+				UsageDetails: plugin.Usage{
+					Usage: `   cf tunnel-replay CF_APPLICATION_NAME REQUEST_ID`,
+					Options: map[string]string{
+						"--times <n>": "send the request this many times",
+						"--edit":      "edit the request in $EDITOR before sending it",
+					},
+				},
+			},
+			{
+				Name:     "service-registry-list",
+				HelpText: "List the applications and instances in the service registry bound to a tunnel application",
+				Alias:    "srl",
+				UsageDetails: plugin.Usage{
+					Usage: `   cf service-registry-list CF_APPLICATION_NAME`,
 				},
 			},
 		},
@@ -317,12 +479,12 @@ func quote(value string) string {
 	return strings.Replace(value, "\"", "&quot;", -1)
 }
 
-func produceEclipseLaunchConfiguration(targetDir string, projectName string, applicationMain string, port string, envVars map[string]string) {
+func produceEclipseLaunchConfiguration(targetDir string, projectName string, applicationMain string, port string, profiles string, envVars map[string]string) {
 
 	extraProps := make(map[string]string)
 	extraProps["spring.boot.prop.eureka.client.register-with-eureka:0"] = "false"
 	extraProps["spring.boot.prop.server.port:1"] = port
-	extraProps["spring.boot.prop.spring.profiles.active:2"] = "cloud"
+	extraProps["spring.boot.prop.spring.profiles.active:2"] = profiles
 
 	funcs := template.FuncMap{"quote": quote}
 	launchConfigInfo := LaunchConfigInfo{projectName, applicationMain, extraProps, envVars}
@@ -391,27 +553,15 @@ func processVars(varData []string) map[string]string {
 	return vars
 }
 
-func unpackManifestTemplateAndFillIn(tempDir string, cfApplicationName string, springApplicationName string, services string, packagedAppPath string) string {
+func unpackManifestTemplateAndFillIn(tempDir string, cfApplicationName string, springApplicationName string, services string, packagedAppPath string, checksum string, settings *TunnelAppSettings) string {
 	data, _ := Asset("resources/manifest.yml.template")
 	manifestTemplateString := string(data)
 
-	//	eureka.client.serviceUrl.defaultZone: SERVICE_REGISTRY_URL
 	manifestTemplateString = strings.Replace(manifestTemplateString, "APPNAME", cfApplicationName, 1)
 	manifestTemplateString = strings.Replace(manifestTemplateString, "PATH", packagedAppPath, 1)
-	// What else the bash did:
-	//if [ ! -z $2 ]
-	//then
-	//echo "    eureka.client.serviceUrl.defaultZone: $2" >> manifest.yml
-	//fi
-	//
-	//if [ ! -z $3 ]
-	//then
-	//# convert it to eureka.instance.metadataMap.key: value
-	//echo "    eureka.instance.metadataMap.needsExplicitRouting: true" >> manifest.yml
-	//echo "    eureka.instance.metadataMap.$3" | sed 's/=/: /' >> manifest.yml
-	//fi
-	//
+	manifestTemplateString = settings.applyTo(manifestTemplateString)
 	manifestTemplateString = manifestTemplateString + "    spring.application.name: " + springApplicationName + "\n"
+	manifestTemplateString = manifestTemplateString + "    TUNNEL_APP_SHA256: " + checksum + "\n"
 
 	if len(services) != 0 {
 		manifestTemplateString += "  services:\n"
@@ -454,9 +604,10 @@ func main() {
 	plugin.Start(&p)
 }
 
-func parseFlagsAndOptions(args []string) (map[string]string, []string, error) {
+func parseFlagsAndOptions(args []string) (map[string]string, map[string][]string, []string, error) {
 	const flagServices = "services"
 	options := make(map[string]string)
+	listOptions := make(map[string][]string)
 	fc := flags.New()
 	// TODO yes this means all commands allow all options, needs a bunch of work doing
 	fc.NewStringFlag(flagServices, "s", "services")
@@ -467,9 +618,84 @@ func parseFlagsAndOptions(args []string) (map[string]string, []string, error) {
 	fc.NewStringFlag("spring-app-name", "spring-app-name", "spring-app-name")
 	fc.NewStringFlag("project", "project", "project")
 	fc.NewStringFlag("application-main", "application-main", "application-main")
+	fc.NewStringFlag("tunnel-app", "tunnel-app", "tunnel-app")
+	fc.NewStringFlag("memory", "memory", "memory")
+	fc.NewStringFlag("disk", "disk", "disk")
+	fc.NewIntFlag("instances", "instances", "instances")
+	fc.NewStringFlag("stack", "stack", "stack")
+	fc.NewStringFlag("buildpack", "buildpack", "buildpack")
+	fc.NewStringSliceFlag("env", "env", "env")
+	fc.NewStringFlag("health-check-type", "health-check-type", "health-check-type")
+	fc.NewStringFlag("health-check-endpoint", "health-check-endpoint", "health-check-endpoint")
+	fc.NewStringFlag("config-file", "config-file", "config-file")
+	fc.NewStringFlag("registry-url", "registry-url", "registry-url")
+	fc.NewStringSliceFlag("metadata", "metadata", "metadata")
+	fc.NewStringFlag("developer", "developer", "developer")
+	fc.NewBoolFlag("register", "register", "register")
+	fc.NewBoolFlag("exclusive", "exclusive", "exclusive")
+	fc.NewStringFlag("file", "f", cli.FileNameUsage)
+	fc.NewBoolFlag("resolve-config", "resolve-config", "resolve-config")
+	fc.NewStringFlag("method", "X", "method")
+	fc.NewStringFlag("data", "d", "data")
+	fc.NewStringSliceFlag("header", "H", "header")
+	fc.NewIntFlag("internal-proxy", "internal-proxy", "internal-proxy")
+	fc.NewIntFlag("socks", "socks", "socks")
+	fc.NewIntFlag("inspect", "inspect", "inspect")
+	fc.NewIntFlag("times", "times", "times")
+	fc.NewBoolFlag("edit", "edit", "edit")
+	fc.NewStringFlag("record", "record", "record")
+	fc.NewStringSliceFlag("redact-header", "redact-header", "redact-header")
+	fc.NewStringFlag("fallback-to", "fallback-to", "fallback-to")
+	fc.NewStringFlag("real-route", "real-route", "real-route")
+	fc.NewIntFlag("split", "split", "split")
+	fc.NewBoolFlag("mirror", "mirror", "mirror")
+	fc.NewStringFlag("inject-latency", "inject-latency", "inject-latency")
+	fc.NewStringFlag("inject-errors", "inject-errors", "inject-errors")
+	fc.NewStringFlag("fault-rules", "fault-rules", "fault-rules")
+	fc.NewIntFlag("control", "control", "control")
+	fc.NewBoolFlag("require-token", "require-token", "require-token")
+	fc.NewStringSliceFlag("allow-cidr", "allow-cidr", "allow-cidr")
+	fc.NewIntFlag("trusted-proxies", "trusted-proxies", "trusted-proxies")
+	fc.NewStringFlag("basic-auth", "basic-auth", "basic-auth")
+	fc.NewIntFlag("max-rps", "max-rps", "max-rps")
+	fc.NewIntFlag("max-conns", "max-conns", "max-conns")
+	fc.NewStringFlag("rewrite-rules", "rewrite-rules", "rewrite-rules")
+	fc.NewBoolFlag("local-tls", "local-tls", "local-tls")
+	fc.NewStringFlag("ca", "ca", "ca")
+	fc.NewStringFlag("cert", "cert", "cert")
+	fc.NewStringFlag("key", "key", "key")
+	fc.NewBoolFlag("generate-certs", "generate-certs", "generate-certs")
+	fc.NewStringFlag("target", "target", "target")
+	fc.NewBoolFlag("status", "status", "status")
+	fc.NewIntFlag("health-port", "health-port", "health-port")
+	fc.NewStringFlag("health-path", "health-path", "health-path")
+	fc.NewStringFlag("copy-network-policies-from", "copy-network-policies-from", "copy-network-policies-from")
 	err := fc.Parse(args...)
 	if err != nil {
-		return nil, nil, fmt.Errorf("Error parsing arguments: %s", err)
+		return nil, nil, nil, fmt.Errorf("Error parsing arguments: %s", err)
+	}
+	for _, name := range []string{"memory", "disk", "stack", "buildpack", "health-check-type", "health-check-endpoint", "config-file", "registry-url", "developer", "file", "method", "data", "copy-network-policies-from", "record", "fallback-to", "real-route", "inject-latency", "inject-errors", "fault-rules", "basic-auth", "rewrite-rules", "ca", "cert", "key", "target", "health-path"} {
+		if fc.IsSet(name) {
+			options[name] = fc.String(name)
+		}
+	}
+	if fc.IsSet("instances") {
+		options["instances"] = fmt.Sprint(fc.Int("instances"))
+	}
+	if fc.IsSet("env") {
+		listOptions["env"] = fc.StringSlice("env")
+	}
+	if fc.IsSet("metadata") {
+		listOptions["metadata"] = fc.StringSlice("metadata")
+	}
+	if fc.IsSet("header") {
+		listOptions["header"] = fc.StringSlice("header")
+	}
+	if fc.IsSet("allow-cidr") {
+		listOptions["allow-cidr"] = fc.StringSlice("allow-cidr")
+	}
+	if fc.IsSet("redact-header") {
+		listOptions["redact-header"] = fc.StringSlice("redact-header")
 	}
 	if fc.IsSet(flagServices) {
 		options[flagServices] = fc.String(flagServices)
@@ -488,14 +714,74 @@ func parseFlagsAndOptions(args []string) (map[string]string, []string, error) {
 	if fc.IsSet("application-main") {
 		options["application-main"] = fc.String("application-main")
 	}
+	if fc.IsSet("internal-proxy") {
+		options["internal-proxy"] = fmt.Sprint(fc.Int("internal-proxy"))
+	}
+	if fc.IsSet("inspect") {
+		options["inspect"] = fmt.Sprint(fc.Int("inspect"))
+	}
+	if fc.IsSet("times") {
+		options["times"] = fmt.Sprint(fc.Int("times"))
+	}
+	if fc.IsSet("max-rps") {
+		options["max-rps"] = fmt.Sprint(fc.Int("max-rps"))
+	}
+	if fc.IsSet("max-conns") {
+		options["max-conns"] = fmt.Sprint(fc.Int("max-conns"))
+	}
+	if fc.IsSet("health-port") {
+		options["health-port"] = fmt.Sprint(fc.Int("health-port"))
+	}
+	if fc.IsSet("trusted-proxies") {
+		options["trusted-proxies"] = fmt.Sprint(fc.Int("trusted-proxies"))
+	}
+	if fc.IsSet("control") {
+		options["control"] = fmt.Sprint(fc.Int("control"))
+	}
+	if fc.IsSet("split") {
+		options["split"] = fmt.Sprint(fc.Int("split"))
+	}
+	if fc.IsSet("socks") {
+		options["socks"] = fmt.Sprint(fc.Int("socks"))
+	}
 	if fc.IsSet("spring-app-name") {
 		options["spring-app-name"] = fc.String("spring-app-name")
 	}
+	if fc.IsSet("tunnel-app") {
+		options["tunnel-app"] = fc.String("tunnel-app")
+	}
 	if fc.IsSet("set") {
 		options["set"] = "true"
 	}
+	if fc.IsSet("register") {
+		options["register"] = "true"
+	}
+	if fc.IsSet("exclusive") {
+		options["exclusive"] = "true"
+	}
+	if fc.IsSet("local-tls") {
+		options["local-tls"] = "true"
+	}
+	if fc.IsSet("generate-certs") {
+		options["generate-certs"] = "true"
+	}
+	if fc.IsSet("require-token") {
+		options["require-token"] = "true"
+	}
+	if fc.IsSet("status") {
+		options["status"] = "true"
+	}
+	if fc.IsSet("mirror") {
+		options["mirror"] = "true"
+	}
+	if fc.IsSet("edit") {
+		options["edit"] = "true"
+	}
+	if fc.IsSet("resolve-config") {
+		options["resolve-config"] = "true"
+	}
 	if fc.IsSet("create-eclipse-launch-config") {
 		options["create-eclipse-launch-config"] = "true"
 	}
-	return options, fc.Args(), nil
+	return options, listOptions, fc.Args(), nil
 }
@@ -0,0 +1,87 @@
+/*
+ * Copyright (C) 2018-Present Pivotal Software, Inc. All rights reserved.
+ *
+ * This program and the accompanying materials are made available under
+ * the terms of the under the Apache License, Version 2.0 (the "License”);
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package main
+
+import (
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"os"
+
+	"github.com/aclement/tunnel-boot/format"
+	"github.com/aclement/tunnel-boot/tunnel"
+)
+
+// A port that is free now. Nothing stops another process taking it before whoever it is passed to
+// listens on it, callers that can't listen themselves (e.g. ssh -D) must be ready to try again.
+func freePort() string {
+	port, err := tunnel.FreePort()
+	if err != nil {
+		format.Diagnose(string(err.Error()), os.Stderr, func() {
+			os.Exit(1)
+		})
+	}
+	return port
+}
+
+// Listen on a local port, failing the command if it is not available
+func listenLocally(port string, purpose string) net.Listener {
+	listener, err := net.Listen("tcp", "127.0.0.1:"+port)
+	if err != nil {
+		format.Diagnose(fmt.Sprintf("Unable to listen on port %s for the %s: %s", port, purpose, err), os.Stderr, func() {
+			os.Exit(1)
+		})
+	}
+	return listener
+}
+
+// Serve the handler on a local port until the tunnel shuts down, returns the port actually used
+func serveLocally(port string, purpose string, handler http.Handler, hooks *shutdownHooks) string {
+	listener := listenLocally(port, purpose)
+	server := &http.Server{Handler: handler}
+	go func() {
+		if err := server.Serve(listener); err != nil && err != http.ErrServerClosed {
+			log.Printf("The %s stopped: %s", purpose, err)
+		}
+	}()
+	hooks.add(func() {
+		server.Close()
+	})
+	return fmt.Sprint(listener.Addr().(*net.TCPAddr).Port)
+}
+
+// Run an HTTP proxy on the local port that the local app can use to reach internal routes, which
+// are dialed through the ssh connection's dynamic forward.
+func startInternalProxy(port string, socksDialer *tunnel.SocksDialer, hooks *shutdownHooks) {
+	serveLocally(port, "internal route proxy", tunnel.NewInternalProxy(socksDialer), hooks)
+
+	settings := fmt.Sprintf("-Dhttp.proxyHost=localhost -Dhttp.proxyPort=%s -Dhttps.proxyHost=localhost -Dhttps.proxyPort=%s", port, port)
+	fmt.Printf("Proxy for *.%s routes listening on localhost:%s, other hosts are reached directly\n", tunnel.InternalDomain, port)
+	fmt.Println("Launch the local app with:")
+	fmt.Println("  " + settings)
+	fmt.Println("or set:")
+	fmt.Printf("  JAVA_TOOL_OPTIONS=\"%s\"\n", settings)
+}
+
+func printSocksSettings(port string) {
+	fmt.Printf("SOCKS5 proxy listening on localhost:%s, connections are opened from inside the tunnel application\n", port)
+	fmt.Println("For a JVM use:")
+	fmt.Printf("  -DsocksProxyHost=localhost -DsocksProxyPort=%s\n", port)
+	fmt.Println("For other tools:")
+	fmt.Printf("  curl --socks5-hostname localhost:%s http://SERVICE.apps.internal:8080/\n", port)
+}
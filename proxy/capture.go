@@ -0,0 +1,171 @@
+/*
+ * Copyright (C) 2018-Present Pivotal Software, Inc. All rights reserved.
+ *
+ * This program and the accompanying materials are made available under
+ * the terms of the under the Apache License, Version 2.0 (the "License”);
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package proxy
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"time"
+)
+
+// Bodies larger than this are only partially captured
+const MaxCapturedBody = 1024 * 1024
+
+// Middleware wraps a handler with some extra behaviour.
+type Middleware func(http.Handler) http.Handler
+
+// Chain wraps the handler with the middlewares, the first middleware sees each request first.
+func Chain(handler http.Handler, middlewares ...Middleware) http.Handler {
+	for i := len(middlewares) - 1; i >= 0; i-- {
+		handler = middlewares[i](handler)
+	}
+	return handler
+}
+
+type exchangeKey struct{}
+
+// ExchangeFrom returns the exchange being captured for the request, if any, so handlers further down
+// the chain can add to it.
+func ExchangeFrom(r *http.Request) *Exchange {
+	exchange, _ := r.Context().Value(exchangeKey{}).(*Exchange)
+	return exchange
+}
+
+// Capture records every request passing through, and the response sent back, in the store.
+func Capture(store *Store) Middleware {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			exchange := &Exchange{
+				Id:      store.newId(),
+				Started: time.Now(),
+				Request: Message{
+					Method:  r.Method,
+					Url:     r.URL.String(),
+					Host:    r.Host,
+					Proto:   r.Proto,
+					Headers: cloneHeader(r.Header),
+				},
+			}
+
+			// Read the start of the body so it is captured even if nobody downstream reads it
+			body, err := ioutil.ReadAll(io.LimitReader(r.Body, MaxCapturedBody))
+			if err != nil {
+				exchange.Error = err.Error()
+			}
+			counter := &countingReader{reader: r.Body}
+			r.Body = struct {
+				io.Reader
+				io.Closer
+			}{io.MultiReader(bytes.NewReader(body), counter), r.Body}
+
+			if replay, ok := r.Context().Value(replayKey{}).(*replayInfo); ok {
+				exchange.ReplayOf = replay.of
+				replay.id = exchange.Id
+			}
+
+			recorder := &responseRecorder{ResponseWriter: w}
+			next.ServeHTTP(recorder, r.WithContext(context.WithValue(r.Context(), exchangeKey{}, exchange)))
+
+			exchange.DurationMs = float64(time.Since(exchange.Started)) / float64(time.Millisecond)
+			exchange.Request.SetBody(body, int64(len(body))+counter.count)
+			status := recorder.status
+			if status == 0 {
+				status = http.StatusOK
+			}
+			exchange.Response = Message{
+				Status:  status,
+				Proto:   r.Proto,
+				Headers: cloneHeader(w.Header()),
+			}
+			exchange.Response.SetBody(recorder.body.Bytes(), recorder.size)
+			if recorder.hijacked {
+				exchange.Error = "connection upgraded, traffic not captured"
+			}
+			store.add(exchange)
+		})
+	}
+}
+
+type countingReader struct {
+	reader io.Reader
+	count  int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.reader.Read(p)
+	c.count += int64(n)
+	return n, err
+}
+
+// responseRecorder passes the response through while keeping a copy of the status and the start of the body
+type responseRecorder struct {
+	http.ResponseWriter
+	status   int
+	body     bytes.Buffer
+	size     int64
+	hijacked bool
+}
+
+func (r *responseRecorder) WriteHeader(status int) {
+	if r.status == 0 {
+		r.status = status
+	}
+	r.ResponseWriter.WriteHeader(status)
+}
+
+func (r *responseRecorder) Write(data []byte) (int, error) {
+	if r.status == 0 {
+		r.status = http.StatusOK
+	}
+	if room := MaxCapturedBody - r.body.Len(); room > 0 {
+		if room > len(data) {
+			room = len(data)
+		}
+		r.body.Write(data[:room])
+	}
+	r.size += int64(len(data))
+	return r.ResponseWriter.Write(data)
+}
+
+func (r *responseRecorder) Flush() {
+	if flusher, ok := r.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+func (r *responseRecorder) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	hijacker, ok := r.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, errors.New("connection cannot be hijacked")
+	}
+	r.hijacked = true
+	return hijacker.Hijack()
+}
+
+func cloneHeader(h http.Header) http.Header {
+	clone := make(http.Header, len(h))
+	for name, values := range h {
+		clone[name] = append([]string(nil), values...)
+	}
+	return clone
+}
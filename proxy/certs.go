@@ -0,0 +1,131 @@
+/*
+ * Copyright (C) 2018-Present Pivotal Software, Inc. All rights reserved.
+ *
+ * This program and the accompanying materials are made available under
+ * the terms of the under the Apache License, Version 2.0 (the "License”);
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package proxy
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"path/filepath"
+	"time"
+)
+
+// Certificates are the PEM files of a throwaway CA, a server certificate for the local app signed by it
+// (valid for localhost) and a client certificate for the tunnel to present.
+type Certificates struct {
+	CaFile         string
+	ServerCertFile string
+	ServerKeyFile  string
+	ClientCertFile string
+	ClientKeyFile  string
+}
+
+// How long generated certificates are valid, they are only meant to last a session
+const certificateValidity = 7 * 24 * time.Hour
+
+// GenerateCertificates creates a new CA, server and client certificate in the directory. The server
+// certificate is for localhost, and for serverName too when the local app is reached by another name
+// or address.
+func GenerateCertificates(dir string, serverName string) (*Certificates, error) {
+	certificates := &Certificates{
+		CaFile:         filepath.Join(dir, "ca.pem"),
+		ServerCertFile: filepath.Join(dir, "server.pem"),
+		ServerKeyFile:  filepath.Join(dir, "server-key.pem"),
+		ClientCertFile: filepath.Join(dir, "client.pem"),
+		ClientKeyFile:  filepath.Join(dir, "client-key.pem"),
+	}
+	caKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	caTemplate := certificateTemplate("tunnel-boot session CA")
+	caTemplate.IsCA = true
+	caTemplate.BasicConstraintsValid = true
+	caTemplate.KeyUsage = x509.KeyUsageCertSign | x509.KeyUsageDigitalSignature
+	caDer, err := x509.CreateCertificate(rand.Reader, caTemplate, caTemplate, &caKey.PublicKey, caKey)
+	if err != nil {
+		return nil, err
+	}
+	ca, err := x509.ParseCertificate(caDer)
+	if err != nil {
+		return nil, err
+	}
+	if err = writePem(certificates.CaFile, "CERTIFICATE", caDer); err != nil {
+		return nil, err
+	}
+
+	server := certificateTemplate("localhost")
+	server.DNSNames = []string{"localhost"}
+	server.IPAddresses = []net.IP{net.ParseIP("127.0.0.1"), net.ParseIP("::1")}
+	if ip := net.ParseIP(serverName); ip != nil {
+		if !ip.Equal(server.IPAddresses[0]) && !ip.Equal(server.IPAddresses[1]) {
+			server.IPAddresses = append(server.IPAddresses, ip)
+		}
+	} else if len(serverName) != 0 && serverName != "localhost" {
+		server.DNSNames = append(server.DNSNames, serverName)
+	}
+	server.ExtKeyUsage = []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth}
+	if err = issue(server, ca, caKey, certificates.ServerCertFile, certificates.ServerKeyFile); err != nil {
+		return nil, err
+	}
+	client := certificateTemplate("tunnel-boot")
+	client.ExtKeyUsage = []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth}
+	if err = issue(client, ca, caKey, certificates.ClientCertFile, certificates.ClientKeyFile); err != nil {
+		return nil, err
+	}
+	return certificates, nil
+}
+
+func certificateTemplate(commonName string) *x509.Certificate {
+	serial, _ := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	return &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{CommonName: commonName, Organization: []string{"tunnel-boot"}},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(certificateValidity),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+	}
+}
+
+func issue(template *x509.Certificate, ca *x509.Certificate, caKey *ecdsa.PrivateKey, certFile string, keyFile string) error {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return err
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, ca, &key.PublicKey, caKey)
+	if err != nil {
+		return err
+	}
+	keyDer, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		return err
+	}
+	if err = writePem(certFile, "CERTIFICATE", der); err != nil {
+		return err
+	}
+	return writePem(keyFile, "PRIVATE KEY", keyDer)
+}
+
+func writePem(file string, blockType string, der []byte) error {
+	return ioutil.WriteFile(file, pem.EncodeToMemory(&pem.Block{Type: blockType, Bytes: der}), 0600)
+}
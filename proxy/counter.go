@@ -0,0 +1,97 @@
+/*
+ * Copyright (C) 2018-Present Pivotal Software, Inc. All rights reserved.
+ *
+ * This program and the accompanying materials are made available under
+ * the terms of the under the Apache License, Version 2.0 (the "License”);
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package proxy
+
+import (
+	"bufio"
+	"errors"
+	"net"
+	"net/http"
+	"sync/atomic"
+)
+
+// RequestCounter counts the requests passing through and how many of them failed (got a 5xx, which
+// includes the local app being unreachable).
+type RequestCounter struct {
+	total    int64
+	failed   int64
+	inFlight int64
+}
+
+// RequestCounts is a snapshot of a RequestCounter.
+type RequestCounts struct {
+	Total    int64 `json:"total"`
+	Failed   int64 `json:"failed"`
+	InFlight int64 `json:"inFlight"`
+}
+
+func (c *RequestCounter) Counts() RequestCounts {
+	return RequestCounts{
+		Total:    atomic.LoadInt64(&c.total),
+		Failed:   atomic.LoadInt64(&c.failed),
+		InFlight: atomic.LoadInt64(&c.inFlight),
+	}
+}
+
+func (c *RequestCounter) Middleware() Middleware {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			atomic.AddInt64(&c.total, 1)
+			atomic.AddInt64(&c.inFlight, 1)
+			defer atomic.AddInt64(&c.inFlight, -1)
+			recorder := &statusRecorder{ResponseWriter: w}
+			next.ServeHTTP(recorder, r)
+			if recorder.status >= http.StatusInternalServerError {
+				atomic.AddInt64(&c.failed, 1)
+			}
+		})
+	}
+}
+
+// statusRecorder notes the status of the response passing through it
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (r *statusRecorder) WriteHeader(status int) {
+	if r.status == 0 {
+		r.status = status
+	}
+	r.ResponseWriter.WriteHeader(status)
+}
+
+func (r *statusRecorder) Write(data []byte) (int, error) {
+	if r.status == 0 {
+		r.status = http.StatusOK
+	}
+	return r.ResponseWriter.Write(data)
+}
+
+func (r *statusRecorder) Flush() {
+	if flusher, ok := r.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+func (r *statusRecorder) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	hijacker, ok := r.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, errors.New("connection cannot be hijacked")
+	}
+	return hijacker.Hijack()
+}
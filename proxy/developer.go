@@ -0,0 +1,76 @@
+/*
+ * Copyright (C) 2018-Present Pivotal Software, Inc. All rights reserved.
+ *
+ * This program and the accompanying materials are made available under
+ * the terms of the under the Apache License, Version 2.0 (the "License”);
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package proxy
+
+import (
+	"log"
+	"net/http"
+)
+
+// Requests say which developer's tunnel they are meant for in this header
+const DeveloperHeader = "X-Tunnel-Developer"
+
+// Set on requests passed to another developer's tunnel, so they are never passed on again
+const bouncedHeader = "X-Tunnel-Bounced"
+
+// ServedByDeveloper marks requests passed on to another developer's tunnel
+const ServedByDeveloper = "developer"
+
+// DeveloperRouter lets several developers tunnel the same service. Whichever tunnel a request arrives at,
+// it ends up with the developer named in its DeveloperHeader: this one's requests go to Local, requests
+// for other developers are passed to their tunnels (found with Tunnel) and requests for nobody in
+// particular are answered by the real application (Real).
+type DeveloperRouter struct {
+	Developer string
+	Local     http.Handler
+	Real      http.Handler
+	RealName  string
+	// Tunnel returns a handler for the named developer's tunnel, and a description of it
+	Tunnel func(developer string) (http.Handler, string, error)
+}
+
+func (d *DeveloperRouter) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	developer := r.Header.Get(DeveloperHeader)
+	switch {
+	case developer == d.Developer:
+		d.Local.ServeHTTP(w, r)
+	case developer == "":
+		markServedBy(w, r, ServedByReal)
+		log.Printf("[%s] %s %s carries no %s header, served by %s", ServedByReal, r.Method, r.URL.RequestURI(), DeveloperHeader, d.RealName)
+		d.Real.ServeHTTP(w, r)
+	case r.Header.Get(bouncedHeader) != "":
+		d.fail(w, r, http.StatusLoopDetected, "the request for "+developer+" was already passed on by another tunnel")
+	default:
+		tunnel, name, err := d.Tunnel(developer)
+		if err != nil {
+			d.fail(w, r, http.StatusBadGateway, err.Error())
+			return
+		}
+		markServedBy(w, r, ServedByDeveloper+" "+developer)
+		log.Printf("[%s] %s %s passed to %s", developer, r.Method, r.URL.RequestURI(), name)
+		r.Header.Set(bouncedHeader, d.Developer)
+		tunnel.ServeHTTP(w, r)
+	}
+}
+
+func (d *DeveloperRouter) fail(w http.ResponseWriter, r *http.Request, status int, reason string) {
+	if exchange := ExchangeFrom(r); exchange != nil {
+		exchange.Error = reason
+	}
+	log.Printf("Unable to route %s %s: %s", r.Method, r.URL.RequestURI(), reason)
+	http.Error(w, "tunnel-boot: "+reason, status)
+}
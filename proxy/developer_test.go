@@ -0,0 +1,77 @@
+/*
+ * Copyright (C) 2018-Present Pivotal Software, Inc. All rights reserved.
+ *
+ * This program and the accompanying materials are made available under
+ * the terms of the under the Apache License, Version 2.0 (the "License”);
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package proxy
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// A handler that answers with its name, so the test can see where a request ended up
+func named(name string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-Handler", name)
+	})
+}
+
+func TestDeveloperRouter(t *testing.T) {
+	router := &DeveloperRouter{
+		Developer: "andy",
+		Local:     named("local"),
+		Real:      named("real"),
+		RealName:  "https://fortunes.example.com",
+		Tunnel: func(developer string) (http.Handler, string, error) {
+			if developer == "chris" {
+				return named("chris"), "https://fortunes-chris.example.com", nil
+			}
+			return nil, "", fmt.Errorf("no tunnel for developer %s", developer)
+		},
+	}
+	tests := []struct {
+		name      string
+		developer string
+		bounced   bool
+		wantCode  int
+		wantServe string
+	}{
+		{"own developer", "andy", false, http.StatusOK, "local"},
+		{"own developer passed on by another tunnel", "andy", true, http.StatusOK, "local"},
+		{"no header", "", false, http.StatusOK, "real"},
+		{"another developer", "chris", false, http.StatusOK, "chris"},
+		{"another developer passed on again", "chris", true, http.StatusLoopDetected, ""},
+		{"unknown developer", "sam", false, http.StatusBadGateway, ""},
+	}
+	for _, test := range tests {
+		r := httptest.NewRequest("GET", "/fortunes", nil)
+		if len(test.developer) != 0 {
+			r.Header.Set(DeveloperHeader, test.developer)
+		}
+		if test.bounced {
+			r.Header.Set(bouncedHeader, "someone")
+		}
+		recorder := httptest.NewRecorder()
+		router.ServeHTTP(recorder, r)
+		if recorder.Code != test.wantCode || recorder.Header().Get("X-Handler") != test.wantServe {
+			t.Errorf("%s: got %d from '%s', want %d from '%s'", test.name, recorder.Code, recorder.Header().Get("X-Handler"), test.wantCode, test.wantServe)
+		}
+		if test.developer == "chris" && !test.bounced && r.Header.Get(bouncedHeader) != "andy" {
+			t.Errorf("%s: request passed on without the %s header", test.name, bouncedHeader)
+		}
+	}
+}
@@ -0,0 +1,143 @@
+/*
+ * Copyright (C) 2018-Present Pivotal Software, Inc. All rights reserved.
+ *
+ * This program and the accompanying materials are made available under
+ * the terms of the under the Apache License, Version 2.0 (the "License”);
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package proxy
+
+import (
+	"encoding/base64"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+// Exchange is a captured request and the response that was returned for it.
+type Exchange struct {
+	Id         string    `json:"id"`
+	Started    time.Time `json:"started"`
+	DurationMs float64   `json:"durationMs"`
+	Request    Message   `json:"request"`
+	Response   Message   `json:"response"`
+	Error      string    `json:"error,omitempty"`
+	ReplayOf   string    `json:"replayOf,omitempty"`
+	ServedBy   string    `json:"servedBy,omitempty"`
+	Fault      string    `json:"fault,omitempty"`
+}
+
+// Message is the captured part of a request or response. Bodies are kept up to a limit, as text when they
+// are valid UTF-8 and base64 otherwise.
+type Message struct {
+	Method        string      `json:"method,omitempty"`
+	Url           string      `json:"url,omitempty"`
+	Host          string      `json:"host,omitempty"`
+	Status        int         `json:"status,omitempty"`
+	Proto         string      `json:"proto"`
+	Headers       http.Header `json:"headers"`
+	Body          string      `json:"body"`
+	BodyEncoding  string      `json:"bodyEncoding,omitempty"`
+	BodySize      int64       `json:"bodySize"`
+	BodyTruncated bool        `json:"bodyTruncated,omitempty"`
+}
+
+// SetBody records the (possibly truncated) body of a message of the given total size.
+func (m *Message) SetBody(body []byte, size int64) {
+	m.BodySize = size
+	m.BodyTruncated = int64(len(body)) < size
+	if utf8.Valid(body) {
+		m.Body = string(body)
+		m.BodyEncoding = ""
+	} else {
+		m.Body = base64.StdEncoding.EncodeToString(body)
+		m.BodyEncoding = "base64"
+	}
+}
+
+// BodyBytes returns the captured body.
+func (m *Message) BodyBytes() []byte {
+	if m.BodyEncoding == "base64" {
+		data, _ := base64.StdEncoding.DecodeString(m.Body)
+		return data
+	}
+	return []byte(m.Body)
+}
+
+// Store keeps the most recent completed exchanges in a ring buffer.
+type Store struct {
+	mutex     sync.Mutex
+	exchanges []*Exchange
+	next      int
+	count     int
+	lastId    int
+	listeners []func(*Exchange)
+}
+
+func NewStore(size int) *Store {
+	if size < 1 {
+		size = 1
+	}
+	return &Store{exchanges: make([]*Exchange, size)}
+}
+
+// OnComplete registers a function called with each exchange as it completes. Listeners must not
+// modify the exchange.
+func (s *Store) OnComplete(listener func(*Exchange)) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.listeners = append(s.listeners, listener)
+}
+
+func (s *Store) newId() string {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.lastId++
+	return strconv.Itoa(s.lastId)
+}
+
+func (s *Store) add(exchange *Exchange) {
+	s.mutex.Lock()
+	s.exchanges[s.next] = exchange
+	s.next = (s.next + 1) % len(s.exchanges)
+	if s.count < len(s.exchanges) {
+		s.count++
+	}
+	listeners := s.listeners
+	s.mutex.Unlock()
+	for _, listener := range listeners {
+		listener(exchange)
+	}
+}
+
+// List returns the stored exchanges, newest first.
+func (s *Store) List() []*Exchange {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	list := make([]*Exchange, 0, s.count)
+	for i := 1; i <= s.count; i++ {
+		list = append(list, s.exchanges[(s.next-i+len(s.exchanges))%len(s.exchanges)])
+	}
+	return list
+}
+
+// Get returns the exchange with the id, or nil if it is not (or no longer) stored.
+func (s *Store) Get(id string) *Exchange {
+	for _, exchange := range s.List() {
+		if exchange.Id == id {
+			return exchange
+		}
+	}
+	return nil
+}
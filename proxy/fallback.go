@@ -0,0 +1,108 @@
+/*
+ * Copyright (C) 2018-Present Pivotal Software, Inc. All rights reserved.
+ *
+ * This program and the accompanying materials are made available under
+ * the terms of the under the Apache License, Version 2.0 (the "License”);
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package proxy
+
+import (
+	"log"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// Responses carry this header saying which side served them
+const ServedByHeader = "X-Tunnel-Served-By"
+
+const (
+	ServedByLocal    = "local"
+	ServedByFallback = "fallback"
+)
+
+// How long a LocalAvailable answer is trusted when Fallback.CheckInterval isn't set
+const DefaultFallbackCheckInterval = time.Second
+
+// Fallback sends requests to the local app while it is accepting connections and to the deployed app
+// (Remote) otherwise, so callers keep getting answers while the local app is stopped. LocalAvailable
+// is asked at most once every CheckInterval, not for every request.
+type Fallback struct {
+	Local          http.Handler
+	Remote         http.Handler
+	RemoteName     string
+	LocalAvailable func() bool
+	CheckInterval  time.Duration
+
+	mutex     sync.Mutex
+	fellBack  bool
+	announced bool
+	available bool
+	checked   time.Time
+}
+
+func (f *Fallback) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	servedBy, handler := ServedByLocal, f.Local
+	if !f.localAvailable() {
+		servedBy, handler = ServedByFallback, f.Remote
+	}
+	f.announce(servedBy == ServedByFallback)
+	markServedBy(w, r, servedBy)
+	if servedBy == ServedByFallback {
+		log.Printf("[%s] %s %s served by %s", servedBy, r.Method, r.URL.RequestURI(), f.RemoteName)
+	}
+	handler.ServeHTTP(w, r)
+}
+
+func (f *Fallback) localAvailable() bool {
+	interval := f.CheckInterval
+	if interval == 0 {
+		interval = DefaultFallbackCheckInterval
+	}
+	f.mutex.Lock()
+	if f.checked.IsZero() {
+		// Nothing to go on yet, everybody waits for the first answer
+		defer f.mutex.Unlock()
+		f.available, f.checked = f.LocalAvailable(), time.Now()
+		return f.available
+	}
+	if time.Since(f.checked) < interval {
+		available := f.available
+		f.mutex.Unlock()
+		return available
+	}
+	// Other requests carry on with the last answer while this one checks
+	f.checked = time.Now()
+	f.mutex.Unlock()
+
+	available := f.LocalAvailable()
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	f.available = available
+	return available
+}
+
+// Print a banner whenever requests switch between the local app and the fallback
+func (f *Fallback) announce(fellBack bool) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	if f.announced && fellBack == f.fellBack {
+		return
+	}
+	f.announced, f.fellBack = true, fellBack
+	if fellBack {
+		log.Printf("*** The local app is not accepting connections, requests are being served by %s ***", f.RemoteName)
+	} else {
+		log.Println("*** Requests are being served by the local app ***")
+	}
+}
@@ -0,0 +1,287 @@
+/*
+ * Copyright (C) 2018-Present Pivotal Software, Inc. All rights reserved.
+ *
+ * This program and the accompanying materials are made available under
+ * the terms of the under the Apache License, Version 2.0 (the "License”);
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package proxy
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/rand"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// FaultRule describes latency and/or errors to inject into matching requests. Paths match exactly, or
+// by prefix when they end in '*'. Rules are tried in order and the first that matches applies.
+type FaultRule struct {
+	Method  string `json:"method,omitempty"`
+	Path    string `json:"path,omitempty"`
+	Latency string `json:"latency,omitempty"`
+	Errors  string `json:"errors,omitempty"`
+	Status  int    `json:"status,omitempty"`
+	// "request" (the default) applies the fault before the local app sees the request, "response" lets
+	// the local app handle it and delays or replaces its response
+	Phase string `json:"phase,omitempty"`
+}
+
+// FaultRules is the format of rule files and of the control endpoint.
+type FaultRules struct {
+	Rules []FaultRule `json:"rules"`
+}
+
+const (
+	PhaseRequest  = "request"
+	PhaseResponse = "response"
+)
+
+type compiledFaultRule struct {
+	FaultRule
+	latency   time.Duration
+	errorRate float64
+}
+
+// FaultInjector applies fault rules, which can be replaced while requests are flowing.
+type FaultInjector struct {
+	mutex sync.RWMutex
+	rules []compiledFaultRule
+}
+
+// SetRules validates and replaces the rules.
+func (f *FaultInjector) SetRules(rules []FaultRule) error {
+	compiled := make([]compiledFaultRule, 0, len(rules))
+	for i, rule := range rules {
+		c := compiledFaultRule{FaultRule: rule}
+		var err error
+		if len(rule.Latency) != 0 {
+			if c.latency, err = time.ParseDuration(rule.Latency); err != nil || c.latency < 0 {
+				return fmt.Errorf("rule %d: invalid latency '%s', expected a duration such as 500ms or 2s", i+1, rule.Latency)
+			}
+		}
+		if len(rule.Errors) != 0 {
+			if c.errorRate, err = ParsePercentage(rule.Errors); err != nil {
+				return fmt.Errorf("rule %d: invalid errors '%s': %s", i+1, rule.Errors, err)
+			}
+		}
+		if rule.Status != 0 && (rule.Status < 100 || rule.Status > 599) {
+			return fmt.Errorf("rule %d: invalid status %d", i+1, rule.Status)
+		}
+		if c.Status == 0 {
+			c.Status = http.StatusServiceUnavailable
+		}
+		if c.Phase == "" {
+			c.Phase = PhaseRequest
+		}
+		if c.Phase != PhaseRequest && c.Phase != PhaseResponse {
+			return fmt.Errorf("rule %d: invalid phase '%s', expected %s or %s", i+1, rule.Phase, PhaseRequest, PhaseResponse)
+		}
+		if len(rule.Path) != 0 && !strings.HasPrefix(rule.Path, "/") {
+			return fmt.Errorf("rule %d: invalid path '%s', must start with '/'", i+1, rule.Path)
+		}
+		c.Method = strings.ToUpper(c.Method)
+		compiled = append(compiled, c)
+	}
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	f.rules = compiled
+	return nil
+}
+
+// Rules returns the rules in force.
+func (f *FaultInjector) Rules() []FaultRule {
+	f.mutex.RLock()
+	defer f.mutex.RUnlock()
+	rules := []FaultRule{}
+	for _, rule := range f.rules {
+		rules = append(rules, rule.FaultRule)
+	}
+	return rules
+}
+
+func (f *FaultInjector) match(r *http.Request) *compiledFaultRule {
+	f.mutex.RLock()
+	defer f.mutex.RUnlock()
+	for i := range f.rules {
+		rule := &f.rules[i]
+		if len(rule.Method) != 0 && rule.Method != r.Method {
+			continue
+		}
+		if len(rule.Path) != 0 {
+			if strings.HasSuffix(rule.Path, "*") {
+				if !strings.HasPrefix(r.URL.Path, strings.TrimSuffix(rule.Path, "*")) {
+					continue
+				}
+			} else if rule.Path != r.URL.Path {
+				continue
+			}
+		}
+		matched := *rule
+		return &matched
+	}
+	return nil
+}
+
+// Middleware applies the matching rule (if any) to each request.
+func (f *FaultInjector) Middleware() Middleware {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			rule := f.match(r)
+			if rule == nil {
+				next.ServeHTTP(w, r)
+				return
+			}
+			fail := rule.errorRate > 0 && rand.Float64()*100 < rule.errorRate
+			var faults []string
+			if rule.latency > 0 {
+				faults = append(faults, "latency "+rule.latency.String()+" on "+rule.Phase)
+			}
+			if fail {
+				faults = append(faults, "error "+strconv.Itoa(rule.Status)+" on "+rule.Phase)
+			}
+			if exchange := ExchangeFrom(r); exchange != nil && len(faults) != 0 {
+				exchange.Fault = strings.Join(faults, ", ")
+			}
+
+			if rule.Phase == PhaseRequest {
+				if !sleep(r, rule.latency) {
+					return
+				}
+				if fail {
+					injectedError(w, rule.Status)
+					return
+				}
+				next.ServeHTTP(w, r)
+				return
+			}
+			next.ServeHTTP(&faultyResponse{ResponseWriter: w, r: r, latency: rule.latency, fail: fail, status: rule.Status}, r)
+		})
+	}
+}
+
+// Wait for the latency, giving up if the caller goes away
+func sleep(r *http.Request, latency time.Duration) bool {
+	if latency <= 0 {
+		return true
+	}
+	timer := time.NewTimer(latency)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return true
+	case <-r.Context().Done():
+		return false
+	}
+}
+
+func injectedError(w http.ResponseWriter, status int) {
+	http.Error(w, fmt.Sprintf("tunnel-boot: injected fault (%d %s)", status, http.StatusText(status)), status)
+}
+
+// faultyResponse holds back the local app's response for the latency, and replaces it with an error
+type faultyResponse struct {
+	http.ResponseWriter
+	r       *http.Request
+	latency time.Duration
+	fail    bool
+	status  int
+	started bool
+}
+
+func (f *faultyResponse) WriteHeader(status int) {
+	if f.started {
+		return
+	}
+	f.started = true
+	sleep(f.r, f.latency)
+	if f.fail {
+		for name := range f.Header() {
+			f.Header().Del(name)
+		}
+		injectedError(f.ResponseWriter, f.status)
+		return
+	}
+	f.ResponseWriter.WriteHeader(status)
+}
+
+func (f *faultyResponse) Write(data []byte) (int, error) {
+	f.WriteHeader(http.StatusOK)
+	if f.fail {
+		return len(data), nil
+	}
+	return f.ResponseWriter.Write(data)
+}
+
+func (f *faultyResponse) Flush() {
+	if flusher, ok := f.ResponseWriter.(http.Flusher); ok && !f.fail {
+		flusher.Flush()
+	}
+}
+
+// Upgraded connections (e.g. websockets) are delayed too. A failing one is refused, the error response
+// then replaces whatever the forwarder reports.
+func (f *faultyResponse) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	hijacker, ok := f.ResponseWriter.(http.Hijacker)
+	if !ok || f.fail {
+		return nil, nil, errors.New("connection cannot be hijacked")
+	}
+	f.started = true
+	sleep(f.r, f.latency)
+	return hijacker.Hijack()
+}
+
+// ControlHandler lets the rules be read (GET), replaced (PUT, a FaultRules document) or cleared
+// (DELETE) while the tunnel is running.
+func (f *FaultInjector) ControlHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case "GET":
+		case "PUT":
+			if err := checkJsonFromSameOrigin(r); err != nil {
+				writeJson(w, http.StatusForbidden, map[string]string{"error": err.Error()})
+				return
+			}
+			rules := &FaultRules{}
+			if err := json.NewDecoder(r.Body).Decode(rules); err != nil {
+				writeJson(w, http.StatusBadRequest, map[string]string{"error": "invalid rules: " + err.Error()})
+				return
+			}
+			if err := f.SetRules(rules.Rules); err != nil {
+				writeJson(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+				return
+			}
+		case "DELETE":
+			f.SetRules(nil)
+		default:
+			writeJson(w, http.StatusMethodNotAllowed, map[string]string{"error": "use GET, PUT or DELETE"})
+			return
+		}
+		writeJson(w, http.StatusOK, &FaultRules{Rules: f.Rules()})
+	})
+}
+
+// ParsePercentage reads a percentage such as 5% or 12.5 (the % is optional).
+func ParsePercentage(value string) (float64, error) {
+	percent, err := strconv.ParseFloat(strings.TrimSuffix(strings.TrimSpace(value), "%"), 64)
+	if err != nil || percent < 0 || percent > 100 {
+		return 0, fmt.Errorf("expected a percentage from 0 to 100")
+	}
+	return percent, nil
+}
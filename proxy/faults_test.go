@@ -0,0 +1,131 @@
+/*
+ * Copyright (C) 2018-Present Pivotal Software, Inc. All rights reserved.
+ *
+ * This program and the accompanying materials are made available under
+ * the terms of the under the Apache License, Version 2.0 (the "License”);
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package proxy
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestParsePercentage(t *testing.T) {
+	tests := []struct {
+		value   string
+		want    float64
+		wantErr bool
+	}{
+		{"0%", 0, false},
+		{"100%", 100, false},
+		{"5%", 5, false},
+		{"12.5", 12.5, false},
+		{" 50% ", 50, false},
+		{"100.1%", 0, true},
+		{"-1%", 0, true},
+		{"lots", 0, true},
+		{"", 0, true},
+	}
+	for _, test := range tests {
+		got, err := ParsePercentage(test.value)
+		if (err != nil) != test.wantErr || got != test.want {
+			t.Errorf("'%s': got %v (error %v), want %v (error %v)", test.value, got, err, test.want, test.wantErr)
+		}
+	}
+}
+
+func TestFaultRuleMatching(t *testing.T) {
+	injector := &FaultInjector{}
+	err := injector.SetRules([]FaultRule{
+		{Method: "post", Path: "/orders", Status: 500},
+		{Path: "/orders", Status: 501},
+		{Path: "/api/*", Status: 502},
+		{Path: "/", Status: 503},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		method string
+		path   string
+		want   int
+	}{
+		{"POST", "/orders", 500},
+		{"GET", "/orders", 501},
+		{"GET", "/orders/1", 0},
+		{"GET", "/api/", 502},
+		{"DELETE", "/api/orders/1", 502},
+		{"GET", "/apis", 0},
+		{"GET", "/", 503},
+	}
+	for _, test := range tests {
+		got := 0
+		if rule := injector.match(httptest.NewRequest(test.method, test.path, nil)); rule != nil {
+			got = rule.Status
+		}
+		if got != test.want {
+			t.Errorf("%s %s: matched the rule for %d, want %d", test.method, test.path, got, test.want)
+		}
+	}
+}
+
+func TestFaultRuleValidation(t *testing.T) {
+	tests := []struct {
+		name string
+		rule FaultRule
+	}{
+		{"latency", FaultRule{Latency: "soon"}},
+		{"negative latency", FaultRule{Latency: "-1s"}},
+		{"errors", FaultRule{Errors: "150%"}},
+		{"status", FaultRule{Status: 99}},
+		{"phase", FaultRule{Phase: "later"}},
+		{"path", FaultRule{Path: "orders"}},
+	}
+	for _, test := range tests {
+		if err := (&FaultInjector{}).SetRules([]FaultRule{test.rule}); err == nil {
+			t.Errorf("invalid %s accepted", test.name)
+		}
+	}
+}
+
+func TestErrorRates(t *testing.T) {
+	tests := []struct {
+		errors string
+		phase  string
+		want   int
+	}{
+		{"0%", PhaseRequest, http.StatusOK},
+		{"0%", PhaseResponse, http.StatusOK},
+		{"100%", PhaseRequest, http.StatusServiceUnavailable},
+		{"100%", PhaseResponse, http.StatusServiceUnavailable},
+	}
+	for _, test := range tests {
+		injector := &FaultInjector{}
+		if err := injector.SetRules([]FaultRule{{Errors: test.errors, Phase: test.phase}}); err != nil {
+			t.Fatal(err)
+		}
+		handler := injector.Middleware()(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte("from the app"))
+		}))
+		for i := 0; i < 50; i++ {
+			recorder := httptest.NewRecorder()
+			handler.ServeHTTP(recorder, httptest.NewRequest("GET", "/", nil))
+			if recorder.Code != test.want {
+				t.Errorf("%s on %s: got %d, want %d", test.errors, test.phase, recorder.Code, test.want)
+				break
+			}
+		}
+	}
+}
@@ -0,0 +1,58 @@
+/*
+ * Copyright (C) 2018-Present Pivotal Software, Inc. All rights reserved.
+ *
+ * This program and the accompanying materials are made available under
+ * the terms of the under the Apache License, Version 2.0 (the "License”);
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package proxy
+
+import (
+	"log"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"time"
+)
+
+// NewForwarder returns the handler at the end of the chain, which sends requests on to the local app
+// at target. The Host header is left as the caller sent it, just as with a plain tcp tunnel.
+func NewForwarder(target *url.URL, transport http.RoundTripper) http.Handler {
+	return newForwarder(target, transport, "the local app", false)
+}
+
+// NewRouteForwarder sends requests to a deployed app through its route, the Host header is changed to
+// the route's so the cf router can find the app.
+func NewRouteForwarder(target *url.URL, transport http.RoundTripper) http.Handler {
+	return newForwarder(target, transport, "the app at", true)
+}
+
+func newForwarder(target *url.URL, transport http.RoundTripper, description string, rewriteHost bool) http.Handler {
+	forwarder := httputil.NewSingleHostReverseProxy(target)
+	if rewriteHost {
+		director := forwarder.Director
+		forwarder.Director = func(r *http.Request) {
+			director(r)
+			r.Host = target.Host
+		}
+	}
+	forwarder.Transport = transport
+	forwarder.FlushInterval = 100 * time.Millisecond
+	forwarder.ErrorHandler = func(w http.ResponseWriter, r *http.Request, err error) {
+		if exchange := ExchangeFrom(r); exchange != nil {
+			exchange.Error = err.Error()
+		}
+		log.Printf("Unable to forward %s %s to %s %s: %s", r.Method, r.URL.RequestURI(), description, target.Host, err)
+		http.Error(w, "tunnel-boot: unable to reach "+description+" "+target.Host+": "+err.Error(), http.StatusBadGateway)
+	}
+	return forwarder
+}
@@ -0,0 +1,184 @@
+/*
+ * Copyright (C) 2018-Present Pivotal Software, Inc. All rights reserved.
+ *
+ * This program and the accompanying materials are made available under
+ * the terms of the under the Apache License, Version 2.0 (the "License”);
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package proxy
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"strings"
+	"sync/atomic"
+)
+
+// Callers present the session token in this header, or in the tunnel_token query parameter
+const (
+	TokenHeader         = "X-Tunnel-Token"
+	TokenQueryParameter = "tunnel_token"
+)
+
+// Guard only lets through requests that pass all of the configured checks. The credentials used are
+// removed before the request is passed on. AllowedNetworks are checked against the ClientAddress, given
+// the number of TrustedProxies in front of the tunnel (1 if not set).
+type Guard struct {
+	Token           string
+	Username        string
+	Password        string
+	AllowedNetworks []*net.IPNet
+	TrustedProxies  int
+
+	rejected int64
+}
+
+// NewToken generates a random session token.
+func NewToken() (string, error) {
+	data := make([]byte, 16)
+	if _, err := rand.Read(data); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(data), nil
+}
+
+// ParseNetworks reads CIDR ranges (or single addresses), each value may be a comma separated list.
+func ParseNetworks(values []string) ([]*net.IPNet, error) {
+	var networks []*net.IPNet
+	for _, value := range values {
+		for _, cidr := range strings.Split(value, ",") {
+			cidr = strings.TrimSpace(cidr)
+			if cidr == "" {
+				continue
+			}
+			if !strings.Contains(cidr, "/") {
+				ip := net.ParseIP(cidr)
+				if ip == nil {
+					return nil, fmt.Errorf("invalid address '%s'", cidr)
+				}
+				bits := 128
+				if ip.To4() != nil {
+					ip, bits = ip.To4(), 32
+				}
+				networks = append(networks, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+				continue
+			}
+			_, network, err := net.ParseCIDR(cidr)
+			if err != nil {
+				return nil, fmt.Errorf("invalid CIDR range '%s'", cidr)
+			}
+			networks = append(networks, network)
+		}
+	}
+	return networks, nil
+}
+
+// ClientAddress is the caller's address. Requests reach the tunnel through the cf routing tier, each proxy
+// on the way appends the address it was connected from to X-Forwarded-For. Callers can put anything they
+// like at the start of the header, so the address is taken trustedProxies entries from the end (1 means
+// the one added by the cf router). Without the header the direct peer is used.
+func ClientAddress(r *http.Request, trustedProxies int) string {
+	if trustedProxies < 1 {
+		trustedProxies = 1
+	}
+	var forwarded []string
+	for _, header := range r.Header[http.CanonicalHeaderKey("X-Forwarded-For")] {
+		forwarded = append(forwarded, strings.Split(header, ",")...)
+	}
+	if len(forwarded) != 0 {
+		// Fewer entries than trusted proxies means they were all added by trusted proxies
+		i := len(forwarded) - trustedProxies
+		if i < 0 {
+			i = 0
+		}
+		return strings.TrimSpace(forwarded[i])
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// Rejected is the number of requests turned away so far.
+func (g *Guard) Rejected() int64 {
+	return atomic.LoadInt64(&g.rejected)
+}
+
+func (g *Guard) Middleware() Middleware {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			client := ClientAddress(r, g.TrustedProxies)
+			if len(g.AllowedNetworks) != 0 && !g.allowed(client) {
+				g.reject(w, r, client, http.StatusForbidden, "address not in an allowed range")
+				return
+			}
+			if len(g.Token) != 0 {
+				token := r.Header.Get(TokenHeader)
+				query := r.URL.Query()
+				if token == "" {
+					token = query.Get(TokenQueryParameter)
+				}
+				if !equal(token, g.Token) {
+					g.reject(w, r, client, http.StatusUnauthorized, "missing or wrong tunnel token")
+					return
+				}
+				r.Header.Del(TokenHeader)
+				if _, ok := query[TokenQueryParameter]; ok {
+					query.Del(TokenQueryParameter)
+					r.URL.RawQuery = query.Encode()
+				}
+			}
+			if len(g.Username) != 0 {
+				username, password, ok := r.BasicAuth()
+				if !ok || !equal(username, g.Username) || !equal(password, g.Password) {
+					w.Header().Set("WWW-Authenticate", `Basic realm="tunnel-boot"`)
+					g.reject(w, r, client, http.StatusUnauthorized, "missing or wrong basic auth credentials")
+					return
+				}
+				r.Header.Del("Authorization")
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+func (g *Guard) allowed(client string) bool {
+	ip := net.ParseIP(client)
+	if ip == nil {
+		return false
+	}
+	for _, network := range g.AllowedNetworks {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+func (g *Guard) reject(w http.ResponseWriter, r *http.Request, client string, status int, reason string) {
+	atomic.AddInt64(&g.rejected, 1)
+	if exchange := ExchangeFrom(r); exchange != nil {
+		exchange.Error = "rejected: " + reason
+	}
+	log.Printf("Rejected %s %s from %s: %s", r.Method, r.URL.Path, client, reason)
+	http.Error(w, "tunnel-boot: "+http.StatusText(status), status)
+}
+
+func equal(a string, b string) bool {
+	return subtle.ConstantTimeCompare([]byte(a), []byte(b)) == 1
+}
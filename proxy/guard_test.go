@@ -0,0 +1,158 @@
+/*
+ * Copyright (C) 2018-Present Pivotal Software, Inc. All rights reserved.
+ *
+ * This program and the accompanying materials are made available under
+ * the terms of the under the Apache License, Version 2.0 (the "License”);
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package proxy
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// Run a request through the guard, returning the response and the request the next handler saw (if any)
+func guarded(guard *Guard, r *http.Request) (*httptest.ResponseRecorder, *http.Request) {
+	var passed *http.Request
+	handler := guard.Middleware()(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		passed = r
+	}))
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, r)
+	return recorder, passed
+}
+
+func TestClientAddress(t *testing.T) {
+	tests := []struct {
+		name           string
+		forwarded      []string
+		trustedProxies int
+		want           string
+	}{
+		{"no header", nil, 1, "192.0.2.10"},
+		{"router only", []string{"203.0.113.5"}, 1, "203.0.113.5"},
+		{"spoofed entry ignored", []string{"10.0.0.1, 203.0.113.5"}, 1, "203.0.113.5"},
+		{"spoofed entry in separate header", []string{"10.0.0.1", "203.0.113.5"}, 1, "203.0.113.5"},
+		{"load balancer in front", []string{"10.0.0.1, 203.0.113.5, 198.51.100.2"}, 2, "203.0.113.5"},
+		{"fewer entries than proxies", []string{"203.0.113.5"}, 2, "203.0.113.5"},
+		{"unset means the router", []string{"10.0.0.1, 203.0.113.5"}, 0, "203.0.113.5"},
+	}
+	for _, test := range tests {
+		r := httptest.NewRequest("GET", "/", nil)
+		r.RemoteAddr = "192.0.2.10:4321"
+		for _, value := range test.forwarded {
+			r.Header.Add("X-Forwarded-For", value)
+		}
+		if got := ClientAddress(r, test.trustedProxies); got != test.want {
+			t.Errorf("%s: got %s, want %s", test.name, got, test.want)
+		}
+	}
+}
+
+func TestGuardRejectsSpoofedForwardedFor(t *testing.T) {
+	networks, err := ParseNetworks([]string{"10.0.0.0/8"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	guard := &Guard{AllowedNetworks: networks}
+
+	r := httptest.NewRequest("GET", "/", nil)
+	r.Header.Set("X-Forwarded-For", "10.0.0.1, 203.0.113.5")
+	if response, passed := guarded(guard, r); response.Code != http.StatusForbidden || passed != nil {
+		t.Errorf("spoofed X-Forwarded-For: got %d, want %d", response.Code, http.StatusForbidden)
+	}
+
+	r = httptest.NewRequest("GET", "/", nil)
+	r.Header.Set("X-Forwarded-For", "203.0.113.5, 10.1.2.3")
+	if response, passed := guarded(guard, r); response.Code != http.StatusOK || passed == nil {
+		t.Errorf("allowed caller: got %d, want %d", response.Code, http.StatusOK)
+	}
+	if guard.Rejected() != 1 {
+		t.Errorf("got %d rejected, want 1", guard.Rejected())
+	}
+}
+
+func TestGuardToken(t *testing.T) {
+	guard := &Guard{Token: "0123456789abcdef"}
+	tests := []struct {
+		name   string
+		header string
+		query  string
+		want   int
+	}{
+		{"missing", "", "", http.StatusUnauthorized},
+		{"wrong", "0123456789abcdee", "", http.StatusUnauthorized},
+		{"prefix", "0123456789", "", http.StatusUnauthorized},
+		{"longer", "0123456789abcdef0", "", http.StatusUnauthorized},
+		{"header", "0123456789abcdef", "", http.StatusOK},
+		{"query", "", "?tunnel_token=0123456789abcdef&a=b", http.StatusOK},
+		{"wrong query", "", "?tunnel_token=nope", http.StatusUnauthorized},
+	}
+	for _, test := range tests {
+		r := httptest.NewRequest("GET", "/path"+test.query, nil)
+		if test.header != "" {
+			r.Header.Set(TokenHeader, test.header)
+		}
+		response, passed := guarded(guard, r)
+		if response.Code != test.want {
+			t.Errorf("%s: got %d, want %d", test.name, response.Code, test.want)
+		}
+		if passed == nil {
+			continue
+		}
+		if passed.Header.Get(TokenHeader) != "" || passed.URL.Query().Get(TokenQueryParameter) != "" {
+			t.Errorf("%s: token passed on to the local app: %v %s", test.name, passed.Header, passed.URL)
+		}
+		if test.query != "" && passed.URL.Query().Get("a") != "b" {
+			t.Errorf("%s: other query parameters lost: %s", test.name, passed.URL)
+		}
+	}
+}
+
+func TestGuardBasicAuth(t *testing.T) {
+	guard := &Guard{Username: "andy", Password: "secret"}
+	tests := []struct {
+		name     string
+		username string
+		password string
+		want     int
+	}{
+		{"missing", "", "", http.StatusUnauthorized},
+		{"wrong password", "andy", "secre", http.StatusUnauthorized},
+		{"wrong user", "bob", "secret", http.StatusUnauthorized},
+		{"right", "andy", "secret", http.StatusOK},
+	}
+	for _, test := range tests {
+		r := httptest.NewRequest("GET", "/", nil)
+		if test.username != "" {
+			r.SetBasicAuth(test.username, test.password)
+		}
+		response, passed := guarded(guard, r)
+		if response.Code != test.want {
+			t.Errorf("%s: got %d, want %d", test.name, response.Code, test.want)
+		}
+		if test.want == http.StatusUnauthorized && response.Header().Get("WWW-Authenticate") == "" {
+			t.Errorf("%s: no WWW-Authenticate challenge", test.name)
+		}
+		if passed != nil && passed.Header.Get("Authorization") != "" {
+			t.Errorf("%s: credentials passed on to the local app", test.name)
+		}
+	}
+}
+
+func TestEqual(t *testing.T) {
+	if !equal("token", "token") || equal("token", "toke") || equal("token", "tokem") || equal("", "token") {
+		t.Error("equal compares incorrectly")
+	}
+}
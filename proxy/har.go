@@ -0,0 +1,272 @@
+/*
+ * Copyright (C) 2018-Present Pivotal Software, Inc. All rights reserved.
+ *
+ * This program and the accompanying materials are made available under
+ * the terms of the under the Apache License, Version 2.0 (the "License”);
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package proxy
+
+import (
+	"encoding/json"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const redacted = "REDACTED"
+
+// HarWriter streams exchanges to an HTTP Archive (HAR 1.2) file. Each entry is written before the closing
+// brackets, which are then rewritten, so the file is valid JSON after every request even if the plugin
+// is killed.
+type HarWriter struct {
+	mutex       sync.Mutex
+	file        *os.File
+	redact      map[string]bool
+	redactQuery map[string]bool
+	entries     int
+	failed      bool
+}
+
+const (
+	harHeader  = `{"log":{"version":"1.2","creator":{"name":"tunnel-boot","version":"1.0"},"entries":[`
+	harTrailer = "\n]}}\n"
+)
+
+// NewHarWriter creates (or truncates) the file. Values of the headers named in redact, and of the query
+// parameters named in redactQuery, are replaced in the archive.
+func NewHarWriter(fileName string, redact []string, redactQuery []string) (*HarWriter, error) {
+	file, err := os.Create(fileName)
+	if err != nil {
+		return nil, err
+	}
+	w := &HarWriter{file: file, redact: make(map[string]bool), redactQuery: make(map[string]bool)}
+	for _, name := range redact {
+		w.redact[http.CanonicalHeaderKey(name)] = true
+	}
+	for _, name := range redactQuery {
+		w.redactQuery[name] = true
+	}
+	if _, err = io.WriteString(file, harHeader+harTrailer); err != nil {
+		file.Close()
+		return nil, err
+	}
+	return w, nil
+}
+
+// Add appends the exchange to the archive. Write failures are logged once and recording stops.
+func (w *HarWriter) Add(exchange *Exchange) {
+	data, err := json.Marshal(w.entry(exchange))
+	if err != nil {
+		log.Println("Unable to record request in HAR file:", err)
+		return
+	}
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	if w.file == nil || w.failed {
+		return
+	}
+	separator := "\n"
+	if w.entries > 0 {
+		separator = ",\n"
+	}
+	if _, err = w.file.Seek(-int64(len(harTrailer)), io.SeekEnd); err == nil {
+		_, err = w.file.WriteString(separator + string(data) + harTrailer)
+	}
+	if err != nil {
+		w.failed = true
+		log.Printf("Unable to write to HAR file %s, recording stopped: %s", w.file.Name(), err)
+		return
+	}
+	w.entries++
+}
+
+// Close finishes the archive.
+func (w *HarWriter) Close() error {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	if w.file == nil {
+		return nil
+	}
+	err := w.file.Close()
+	w.file = nil
+	return err
+}
+
+type harNameValue struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+
+type harPostData struct {
+	MimeType string `json:"mimeType"`
+	Text     string `json:"text"`
+	Comment  string `json:"comment,omitempty"`
+}
+
+type harContent struct {
+	Size     int64  `json:"size"`
+	MimeType string `json:"mimeType"`
+	Text     string `json:"text,omitempty"`
+	Encoding string `json:"encoding,omitempty"`
+	Comment  string `json:"comment,omitempty"`
+}
+
+type harRequest struct {
+	Method      string         `json:"method"`
+	Url         string         `json:"url"`
+	HttpVersion string         `json:"httpVersion"`
+	Cookies     []harNameValue `json:"cookies"`
+	Headers     []harNameValue `json:"headers"`
+	QueryString []harNameValue `json:"queryString"`
+	PostData    *harPostData   `json:"postData,omitempty"`
+	HeadersSize int            `json:"headersSize"`
+	BodySize    int64          `json:"bodySize"`
+}
+
+type harResponse struct {
+	Status      int            `json:"status"`
+	StatusText  string         `json:"statusText"`
+	HttpVersion string         `json:"httpVersion"`
+	Cookies     []harNameValue `json:"cookies"`
+	Headers     []harNameValue `json:"headers"`
+	Content     harContent     `json:"content"`
+	RedirectURL string         `json:"redirectURL"`
+	HeadersSize int            `json:"headersSize"`
+	BodySize    int64          `json:"bodySize"`
+}
+
+type harTimings struct {
+	Send    float64 `json:"send"`
+	Wait    float64 `json:"wait"`
+	Receive float64 `json:"receive"`
+}
+
+type harEntry struct {
+	StartedDateTime string      `json:"startedDateTime"`
+	Time            float64     `json:"time"`
+	Request         harRequest  `json:"request"`
+	Response        harResponse `json:"response"`
+	Cache           struct{}    `json:"cache"`
+	Timings         harTimings  `json:"timings"`
+	Comment         string      `json:"comment,omitempty"`
+}
+
+func (w *HarWriter) entry(exchange *Exchange) *harEntry {
+	request, response := &exchange.Request, &exchange.Response
+	// Requests reach the tunnel through the cf router, which says how the caller connected
+	scheme := request.Headers.Get("X-Forwarded-Proto")
+	if scheme == "" {
+		scheme = "http"
+	}
+	entry := &harEntry{
+		StartedDateTime: exchange.Started.Format(time.RFC3339Nano),
+		Time:            exchange.DurationMs,
+		Request: harRequest{
+			Method:      request.Method,
+			Url:         scheme + "://" + request.Host + request.Url,
+			HttpVersion: request.Proto,
+			Cookies:     []harNameValue{},
+			Headers:     w.headers(request.Headers),
+			QueryString: []harNameValue{},
+			HeadersSize: -1,
+			BodySize:    request.BodySize,
+		},
+		Response: harResponse{
+			Status:      response.Status,
+			StatusText:  http.StatusText(response.Status),
+			HttpVersion: response.Proto,
+			Cookies:     []harNameValue{},
+			Headers:     w.headers(response.Headers),
+			Content: harContent{
+				Size:     response.BodySize,
+				MimeType: response.Headers.Get("Content-Type"),
+				Text:     response.Body,
+				Encoding: response.BodyEncoding,
+				Comment:  truncatedComment(response),
+			},
+			RedirectURL: response.Headers.Get("Location"),
+			HeadersSize: -1,
+			BodySize:    response.BodySize,
+		},
+		Timings: harTimings{Wait: exchange.DurationMs},
+		Comment: exchange.Error,
+	}
+	if u, err := url.Parse(request.Url); err == nil {
+		query := u.Query()
+		redactedQuery := false
+		for _, name := range sortedKeys(query) {
+			for i, value := range query[name] {
+				if w.redactQuery[name] {
+					value, query[name][i], redactedQuery = redacted, redacted, true
+				}
+				entry.Request.QueryString = append(entry.Request.QueryString, harNameValue{name, value})
+			}
+		}
+		if redactedQuery {
+			u.RawQuery = query.Encode()
+			entry.Request.Url = scheme + "://" + request.Host + u.String()
+		}
+	}
+	if request.BodySize > 0 {
+		postData := &harPostData{MimeType: request.Headers.Get("Content-Type"), Text: request.Body, Comment: truncatedComment(request)}
+		if request.BodyEncoding == "base64" {
+			postData.Comment = strings.TrimSpace("base64 encoded " + postData.Comment)
+		}
+		entry.Request.PostData = postData
+	}
+	if len(exchange.Fault) != 0 {
+		entry.Comment = strings.TrimSpace("injected " + exchange.Fault + " " + entry.Comment)
+	}
+	if len(exchange.ServedBy) != 0 {
+		entry.Comment = strings.TrimSpace("served by " + exchange.ServedBy + " " + entry.Comment)
+	}
+	if len(exchange.ReplayOf) != 0 {
+		entry.Comment = strings.TrimSpace("replay of #" + exchange.ReplayOf + " " + entry.Comment)
+	}
+	return entry
+}
+
+func (w *HarWriter) headers(headers http.Header) []harNameValue {
+	list := []harNameValue{}
+	for _, name := range sortedKeys(headers) {
+		for _, value := range headers[name] {
+			if w.redact[http.CanonicalHeaderKey(name)] {
+				value = redacted
+			}
+			list = append(list, harNameValue{name, value})
+		}
+	}
+	return list
+}
+
+func truncatedComment(message *Message) string {
+	if message.BodyTruncated {
+		return "body truncated"
+	}
+	return ""
+}
+
+func sortedKeys(values map[string][]string) []string {
+	keys := make([]string, 0, len(values))
+	for key := range values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,120 @@
+/*
+ * Copyright (C) 2018-Present Pivotal Software, Inc. All rights reserved.
+ *
+ * This program and the accompanying materials are made available under
+ * the terms of the under the Apache License, Version 2.0 (the "License”);
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package proxy
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+type harFile struct {
+	Log struct {
+		Version string     `json:"version"`
+		Entries []harEntry `json:"entries"`
+	} `json:"log"`
+}
+
+// Read the archive back, failing the test if it isn't valid JSON
+func readHar(t *testing.T, fileName string) *harFile {
+	data, err := ioutil.ReadFile(fileName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	har := &harFile{}
+	if err = json.Unmarshal(data, har); err != nil {
+		t.Fatalf("invalid HAR file: %s\n%s", err, data)
+	}
+	return har
+}
+
+func testExchange(method string, requestUrl string, headers http.Header, body string) *Exchange {
+	return &Exchange{
+		Started:  time.Now(),
+		Request:  Message{Method: method, Url: requestUrl, Host: "fortunes.example.com", Proto: "HTTP/1.1", Headers: headers, Body: body, BodySize: int64(len(body))},
+		Response: Message{Status: http.StatusOK, Proto: "HTTP/1.1", Headers: http.Header{"Content-Type": {"text/plain"}}, Body: "ok", BodySize: 2},
+	}
+}
+
+func TestHarFileStaysValid(t *testing.T) {
+	fileName := filepath.Join(t.TempDir(), "requests.har")
+	har, err := NewHarWriter(fileName, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if entries := readHar(t, fileName).Log.Entries; len(entries) != 0 {
+		t.Errorf("new file: got %d entries, want 0", len(entries))
+	}
+
+	exchanges := []*Exchange{
+		testExchange("GET", "/fortunes", http.Header{}, ""),
+		// A body that looks like the end of the archive mustn't confuse the rewrite of the trailer
+		testExchange("POST", "/fortunes", http.Header{"Content-Type": {"application/json"}}, "\n]}}\n"),
+		testExchange("DELETE", "/fortunes/1", http.Header{}, ""),
+	}
+	for i, exchange := range exchanges {
+		har.Add(exchange)
+		entries := readHar(t, fileName).Log.Entries
+		if len(entries) != i+1 {
+			t.Fatalf("after %d requests: got %d entries", i+1, len(entries))
+		}
+		if entries[i].Request.Method != exchange.Request.Method {
+			t.Errorf("entry %d: got %s, want %s", i, entries[i].Request.Method, exchange.Request.Method)
+		}
+	}
+	if text := readHar(t, fileName).Log.Entries[1].Request.PostData.Text; text != "\n]}}\n" {
+		t.Errorf("got body %q", text)
+	}
+
+	if err = har.Close(); err != nil {
+		t.Fatal(err)
+	}
+	har.Add(testExchange("GET", "/late", http.Header{}, ""))
+	if log := readHar(t, fileName).Log; log.Version != "1.2" || len(log.Entries) != len(exchanges) {
+		t.Errorf("after close: got version %s with %d entries, want 1.2 with %d", log.Version, len(log.Entries), len(exchanges))
+	}
+}
+
+func TestHarRedaction(t *testing.T) {
+	fileName := filepath.Join(t.TempDir(), "requests.har")
+	har, err := NewHarWriter(fileName, []string{"authorization", TokenHeader}, []string{TokenQueryParameter})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer har.Close()
+	headers := http.Header{"Authorization": {"Basic YW5keTpzZWNyZXQ="}, TokenHeader: {"abc"}, "Accept": {"text/plain"}}
+	har.Add(testExchange("GET", "/fortunes?page=2&"+TokenQueryParameter+"=abc", headers, ""))
+
+	entry := readHar(t, fileName).Log.Entries[0]
+	want := map[string]string{"Authorization": redacted, http.CanonicalHeaderKey(TokenHeader): redacted, "Accept": "text/plain"}
+	for _, header := range entry.Request.Headers {
+		if header.Value != want[header.Name] {
+			t.Errorf("header %s: got %s, want %s", header.Name, header.Value, want[header.Name])
+		}
+	}
+	if entry.Request.Url != "http://fortunes.example.com/fortunes?page=2&"+TokenQueryParameter+"="+redacted {
+		t.Errorf("got url %s", entry.Request.Url)
+	}
+	for _, parameter := range entry.Request.QueryString {
+		if parameter.Name == TokenQueryParameter && parameter.Value != redacted {
+			t.Errorf("query parameter %s: got %s, want %s", parameter.Name, parameter.Value, redacted)
+		}
+	}
+}
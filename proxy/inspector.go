@@ -0,0 +1,183 @@
+/*
+ * Copyright (C) 2018-Present Pivotal Software, Inc. All rights reserved.
+ *
+ * This program and the accompanying materials are made available under
+ * the terms of the under the Apache License, Version 2.0 (the "License”);
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package proxy
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"mime"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Summary is the list view of an exchange.
+type Summary struct {
+	Id         string    `json:"id"`
+	Started    time.Time `json:"started"`
+	DurationMs float64   `json:"durationMs"`
+	Method     string    `json:"method"`
+	Url        string    `json:"url"`
+	Status     int       `json:"status"`
+	Error      string    `json:"error,omitempty"`
+	ReplayOf   string    `json:"replayOf,omitempty"`
+	ServedBy   string    `json:"servedBy,omitempty"`
+}
+
+// NewInspector returns the handler for the inspector web UI and its JSON API:
+//
+//	GET /api/requests        summaries of the stored exchanges, newest first
+//	GET /api/requests/ID     one exchange with headers and bodies
+//	POST /api/replay         send a ReplayRequest through the handler, returns a ReplayResult
+func NewInspector(store *Store, handler http.Handler) http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/replay", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "POST" {
+			writeJson(w, http.StatusMethodNotAllowed, map[string]string{"error": "use POST"})
+			return
+		}
+		if err := checkJsonFromSameOrigin(r); err != nil {
+			writeJson(w, http.StatusForbidden, map[string]string{"error": err.Error()})
+			return
+		}
+		replay := &ReplayRequest{}
+		if err := json.NewDecoder(r.Body).Decode(replay); err != nil {
+			writeJson(w, http.StatusBadRequest, map[string]string{"error": "invalid replay request: " + err.Error()})
+			return
+		}
+		result, err := Replay(handler, replay)
+		if err != nil {
+			writeJson(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+			return
+		}
+		writeJson(w, http.StatusOK, result)
+	})
+	mux.HandleFunc("/api/requests", func(w http.ResponseWriter, r *http.Request) {
+		summaries := []Summary{}
+		for _, exchange := range store.List() {
+			summaries = append(summaries, Summary{
+				Id:         exchange.Id,
+				Started:    exchange.Started,
+				DurationMs: exchange.DurationMs,
+				Method:     exchange.Request.Method,
+				Url:        exchange.Request.Url,
+				Status:     exchange.Response.Status,
+				Error:      exchange.Error,
+				ReplayOf:   exchange.ReplayOf,
+				ServedBy:   exchange.ServedBy,
+			})
+		}
+		writeJson(w, http.StatusOK, summaries)
+	})
+	mux.HandleFunc("/api/requests/", func(w http.ResponseWriter, r *http.Request) {
+		exchange := store.Get(strings.TrimPrefix(r.URL.Path, "/api/requests/"))
+		if exchange == nil {
+			writeJson(w, http.StatusNotFound, map[string]string{"error": "no such request, it may have dropped out of the buffer"})
+			return
+		}
+		writeJson(w, http.StatusOK, exchange)
+	})
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.Write([]byte(inspectorPage))
+	})
+	return mux
+}
+
+// Requests that change things must be JSON and, when they come from a browser, from our own pages.
+// Otherwise any web page open in the developer's browser could post a simple form or text/plain
+// request to the local port.
+func checkJsonFromSameOrigin(r *http.Request) error {
+	if mediaType, _, err := mime.ParseMediaType(r.Header.Get("Content-Type")); err != nil || mediaType != "application/json" {
+		return errors.New("the request must have Content-Type: application/json")
+	}
+	if origin := r.Header.Get("Origin"); len(origin) != 0 && origin != "http://"+r.Host {
+		return fmt.Errorf("requests from %s are not allowed", origin)
+	}
+	return nil
+}
+
+func writeJson(w http.ResponseWriter, status int, value interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	encoder.Encode(value)
+}
+
+const inspectorPage = `<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>tunnel-boot inspector</title>
+<style>
+body { font-family: sans-serif; margin: 0; display: flex; height: 100vh; }
+#list { width: 45%; overflow-y: auto; border-right: 1px solid #ccc; }
+#detail { flex: 1; overflow-y: auto; padding: 0 1em; }
+table { border-collapse: collapse; width: 100%; font-size: 13px; }
+td, th { text-align: left; padding: 4px 6px; border-bottom: 1px solid #eee; white-space: nowrap; }
+tr.row { cursor: pointer; }
+tr.row:hover, tr.selected { background: #e8f0fe; }
+.error { color: #b00; }
+pre { background: #f6f6f6; padding: 6px; white-space: pre-wrap; word-break: break-all; font-size: 12px; }
+</style>
+</head>
+<body>
+<div id="list"><table><thead><tr><th>Time</th><th>Method</th><th>Path</th><th>Status</th><th>Duration</th></tr></thead><tbody id="rows"></tbody></table></div>
+<div id="detail"><p>Select a request</p></div>
+<script>
+var selected = null;
+function esc(s) { var d = document.createElement('div'); d.textContent = s; return d.innerHTML; }
+function headers(h) { return Object.keys(h || {}).sort().map(function (k) { return h[k].map(function (v) { return esc(k + ': ' + v); }).join('\n'); }).join('\n'); }
+function body(m) {
+  if (!m.bodySize) { return '<p><i>no body</i></p>'; }
+  var note = m.bodySize + ' bytes' + (m.bodyTruncated ? ', truncated' : '') + (m.bodyEncoding ? ', ' + m.bodyEncoding : '');
+  return '<p>Body (' + note + ')</p><pre>' + esc(m.body) + '</pre>';
+}
+function show(id) {
+  selected = id;
+  fetch('/api/requests/' + id).then(function (r) { return r.json(); }).then(function (e) {
+    if (e.error && !e.id) { document.getElementById('detail').innerHTML = '<p class="error">' + esc(e.error) + '</p>'; return; }
+    document.getElementById('detail').innerHTML =
+      '<h3>#' + esc(e.id) + ' ' + esc(e.request.method) + ' ' + esc(e.request.url) + '</h3>' +
+      '<p>' + esc(e.started) + ', ' + e.durationMs.toFixed(1) + ' ms' + (e.servedBy ? ', served by ' + esc(e.servedBy) : '') + (e.fault ? ', injected ' + esc(e.fault) : '') + (e.error ? ' <span class="error">' + esc(e.error) + '</span>' : '') + '</p>' +
+      '<h4>Request</h4><pre>Host: ' + esc(e.request.host) + '\n' + headers(e.request.headers) + '</pre>' + body(e.request) +
+      '<h4>Response ' + e.response.status + '</h4><pre>' + headers(e.response.headers) + '</pre>' + body(e.response);
+  });
+  refresh();
+}
+function refresh() {
+  fetch('/api/requests').then(function (r) { return r.json(); }).then(function (list) {
+    document.getElementById('rows').innerHTML = list.map(function (s) {
+      return '<tr class="row' + (s.id === selected ? ' selected' : '') + '" onclick="show(\'' + esc(s.id) + '\')">' +
+        '<td>' + esc(new Date(s.started).toLocaleTimeString()) + '</td><td>' + esc(s.method) + '</td><td>' + esc(s.url) + '</td>' +
+        '<td' + (s.status >= 500 || s.error ? ' class="error"' : '') + '>' + s.status + '</td><td>' + s.durationMs.toFixed(1) + ' ms' +
+        (s.replayOf ? ' (replay of #' + esc(s.replayOf) + ')' : '') + (s.servedBy ? ' [' + esc(s.servedBy) + ']' : '') + '</td></tr>';
+    }).join('');
+  });
+}
+refresh();
+setInterval(refresh, 2000);
+</script>
+</body>
+</html>
+`
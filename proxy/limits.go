@@ -0,0 +1,146 @@
+/*
+ * Copyright (C) 2018-Present Pivotal Software, Inc. All rights reserved.
+ *
+ * This program and the accompanying materials are made available under
+ * the terms of the under the Apache License, Version 2.0 (the "License”);
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package proxy
+
+import (
+	"log"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Limiter protects the local app from floods: requests above MaxRps are rejected with a 429, and
+// requests beyond MaxConns in progress wait up to QueueTimeout for a slot before getting a 503.
+// Zero means no limit.
+type Limiter struct {
+	MaxRps       int
+	MaxConns     int
+	QueueTimeout time.Duration
+
+	once       sync.Once
+	bucket     *tokenBucket
+	slots      chan struct{}
+	passed     int64
+	shedRate   int64
+	shedConns  int64
+	queued     int64
+	lastLogged int64
+}
+
+// LimiterStats counts what the limiter has done.
+type LimiterStats struct {
+	Passed        int64 `json:"passed"`
+	Queued        int64 `json:"queued"`
+	ShedRateLimit int64 `json:"shedRateLimit"`
+	ShedConnLimit int64 `json:"shedConnLimit"`
+}
+
+func (l *Limiter) Stats() LimiterStats {
+	return LimiterStats{
+		Passed:        atomic.LoadInt64(&l.passed),
+		Queued:        atomic.LoadInt64(&l.queued),
+		ShedRateLimit: atomic.LoadInt64(&l.shedRate),
+		ShedConnLimit: atomic.LoadInt64(&l.shedConns),
+	}
+}
+
+func (l *Limiter) init() {
+	l.once.Do(func() {
+		if l.MaxRps > 0 {
+			l.bucket = &tokenBucket{rate: float64(l.MaxRps), tokens: float64(l.MaxRps), last: time.Now()}
+		}
+		if l.MaxConns > 0 {
+			l.slots = make(chan struct{}, l.MaxConns)
+		}
+	})
+}
+
+func (l *Limiter) Middleware() Middleware {
+	l.init()
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if l.bucket != nil && !l.bucket.take() {
+				l.shed(w, r, &l.shedRate, http.StatusTooManyRequests, "over the rate limit")
+				return
+			}
+			if l.slots != nil {
+				select {
+				case l.slots <- struct{}{}:
+				default:
+					// Full, wait a while for a request to finish
+					atomic.AddInt64(&l.queued, 1)
+					timer := time.NewTimer(l.QueueTimeout)
+					select {
+					case l.slots <- struct{}{}:
+						timer.Stop()
+					case <-timer.C:
+						l.shed(w, r, &l.shedConns, http.StatusServiceUnavailable, "too many requests in progress")
+						return
+					case <-r.Context().Done():
+						timer.Stop()
+						return
+					}
+				}
+				defer func() { <-l.slots }()
+			}
+			atomic.AddInt64(&l.passed, 1)
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+func (l *Limiter) shed(w http.ResponseWriter, r *http.Request, counter *int64, status int, reason string) {
+	atomic.AddInt64(counter, 1)
+	if exchange := ExchangeFrom(r); exchange != nil {
+		exchange.Error = "shed: " + reason
+	}
+	// Don't flood the console as well, log at most once a second
+	now := time.Now().Unix()
+	if last := atomic.LoadInt64(&l.lastLogged); now != last && atomic.CompareAndSwapInt64(&l.lastLogged, last, now) {
+		stats := l.Stats()
+		log.Printf("Shedding requests (%s), %d over the rate limit and %d over the connection limit so far", reason, stats.ShedRateLimit, stats.ShedConnLimit)
+	}
+	if status == http.StatusTooManyRequests {
+		w.Header().Set("Retry-After", "1")
+	}
+	http.Error(w, "tunnel-boot: "+http.StatusText(status)+", "+reason, status)
+}
+
+// tokenBucket allows rate requests a second, with bursts of up to a second's worth
+type tokenBucket struct {
+	mutex  sync.Mutex
+	rate   float64
+	tokens float64
+	last   time.Time
+}
+
+func (b *tokenBucket) take() bool {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	now := time.Now()
+	b.tokens += now.Sub(b.last).Seconds() * b.rate
+	if b.tokens > b.rate {
+		b.tokens = b.rate
+	}
+	b.last = now
+	if b.tokens < 1 {
+		return false
+	}
+	b.tokens--
+	return true
+}
@@ -0,0 +1,90 @@
+/*
+ * Copyright (C) 2018-Present Pivotal Software, Inc. All rights reserved.
+ *
+ * This program and the accompanying materials are made available under
+ * the terms of the under the Apache License, Version 2.0 (the "License”);
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package proxy
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// Send the request through the limiter to handler
+func limited(handler http.Handler) *httptest.ResponseRecorder {
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, httptest.NewRequest("GET", "/", nil))
+	return recorder
+}
+
+func TestRateLimitBurst(t *testing.T) {
+	for _, maxRps := range []int{1, 5, 20} {
+		limiter := &Limiter{MaxRps: maxRps}
+		handler := limiter.Middleware()(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+		for i := 0; i < maxRps; i++ {
+			if response := limited(handler); response.Code != http.StatusOK {
+				t.Errorf("max rps %d: request %d got %d, want %d", maxRps, i+1, response.Code, http.StatusOK)
+			}
+		}
+		response := limited(handler)
+		if response.Code != http.StatusTooManyRequests || response.Header().Get("Retry-After") != "1" {
+			t.Errorf("max rps %d: request over the limit got %d (Retry-After '%s'), want %d", maxRps, response.Code, response.Header().Get("Retry-After"), http.StatusTooManyRequests)
+		}
+		if stats := limiter.Stats(); stats.Passed != int64(maxRps) || stats.ShedRateLimit != 1 {
+			t.Errorf("max rps %d: got stats %+v", maxRps, stats)
+		}
+	}
+}
+
+func TestConnectionLimitQueue(t *testing.T) {
+	limiter := &Limiter{MaxConns: 2, QueueTimeout: 50 * time.Millisecond}
+	started := make(chan struct{})
+	release := make(chan struct{})
+	handler := limiter.Middleware()(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		started <- struct{}{}
+		<-release
+	}))
+
+	// Fill the slots
+	done := make(chan int)
+	for i := 0; i < limiter.MaxConns; i++ {
+		go func() { done <- limited(handler).Code }()
+		<-started
+	}
+
+	// Nothing finishes, so the next request waits for the queue timeout and is turned away
+	if response := limited(handler); response.Code != http.StatusServiceUnavailable {
+		t.Errorf("request over the limit got %d, want %d", response.Code, http.StatusServiceUnavailable)
+	}
+
+	// A request that queues gets the slot of one that finishes
+	limiter.QueueTimeout = 5 * time.Second
+	go func() { done <- limited(handler).Code }()
+	for limiter.Stats().Queued != 2 {
+		time.Sleep(time.Millisecond)
+	}
+	release <- struct{}{}
+	<-started
+	close(release)
+	for i := 0; i < limiter.MaxConns+1; i++ {
+		if code := <-done; code != http.StatusOK {
+			t.Errorf("request in a slot got %d, want %d", code, http.StatusOK)
+		}
+	}
+	if stats := limiter.Stats(); stats.Passed != 3 || stats.Queued != 2 || stats.ShedConnLimit != 1 {
+		t.Errorf("got stats %+v", stats)
+	}
+}
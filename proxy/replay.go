@@ -0,0 +1,82 @@
+/*
+ * Copyright (C) 2018-Present Pivotal Software, Inc. All rights reserved.
+ *
+ * This program and the accompanying materials are made available under
+ * the terms of the under the Apache License, Version 2.0 (the "License”);
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package proxy
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"time"
+)
+
+// ReplayRequest asks for a (possibly edited) captured request to be sent again.
+type ReplayRequest struct {
+	ReplayOf string  `json:"replayOf"`
+	Request  Message `json:"request"`
+}
+
+// ReplayResult is the outcome of a replay, Id is the exchange it was captured as.
+type ReplayResult struct {
+	Id         string  `json:"id,omitempty"`
+	DurationMs float64 `json:"durationMs"`
+	Response   Message `json:"response"`
+}
+
+type replayKey struct{}
+
+type replayInfo struct {
+	of string
+	id string
+}
+
+// Replay sends the request through the handler as if it had just arrived through the tunnel.
+func Replay(handler http.Handler, replay *ReplayRequest) (*ReplayResult, error) {
+	message := replay.Request
+	if message.BodyTruncated {
+		return nil, fmt.Errorf("The body of the request was only partially captured (%d bytes), it cannot be replayed", message.BodySize)
+	}
+	body := message.BodyBytes()
+	req, err := http.NewRequest(message.Method, message.Url, bytes.NewReader(body))
+	if err != nil {
+		return nil, fmt.Errorf("Invalid request to replay: %s", err)
+	}
+	req.RequestURI = message.Url
+	req.Host = message.Host
+	req.RemoteAddr = "127.0.0.1:0"
+	req.Header = cloneHeader(message.Headers)
+	req.Header.Del("Content-Length")
+	req.ContentLength = int64(len(body))
+	info := &replayInfo{of: replay.ReplayOf}
+	req = req.WithContext(context.WithValue(req.Context(), replayKey{}, info))
+
+	recorder := httptest.NewRecorder()
+	started := time.Now()
+	handler.ServeHTTP(recorder, req)
+	result := &ReplayResult{
+		Id:         info.id,
+		DurationMs: float64(time.Since(started)) / float64(time.Millisecond),
+		Response: Message{
+			Status:  recorder.Code,
+			Proto:   "HTTP/1.1",
+			Headers: recorder.Header(),
+		},
+	}
+	result.Response.SetBody(recorder.Body.Bytes(), int64(recorder.Body.Len()))
+	return result, nil
+}
@@ -0,0 +1,249 @@
+/*
+ * Copyright (C) 2018-Present Pivotal Software, Inc. All rights reserved.
+ *
+ * This program and the accompanying materials are made available under
+ * the terms of the under the Apache License, Version 2.0 (the "License”);
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package proxy
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// RewriteRules adapt requests to what the local app expects, and its responses back to what callers expect.
+// Header rules are applied in the order remove, set, add, rewrite. The Host header can be changed too.
+type RewriteRules struct {
+	RequestHeaders  HeaderRules `json:"requestHeaders"`
+	ResponseHeaders HeaderRules `json:"responseHeaders"`
+	// Removed from the start of request paths (e.g. /api when the route maps /api to the app)
+	StripPrefix string `json:"stripPrefix,omitempty"`
+	// Added to the start of request paths (after any StripPrefix)
+	AddPrefix string `json:"addPrefix,omitempty"`
+	// Turn Location headers pointing at the local app back into URLs on the route the caller used,
+	// undoing the path prefix changes
+	RewriteLocation bool `json:"rewriteLocation,omitempty"`
+}
+
+type HeaderRules struct {
+	Remove  []string          `json:"remove,omitempty"`
+	Set     map[string]string `json:"set,omitempty"`
+	Add     map[string]string `json:"add,omitempty"`
+	Rewrite []HeaderRewrite   `json:"rewrite,omitempty"`
+}
+
+// HeaderRewrite replaces matches of the regular expression in the header's values, the replacement
+// can refer to groups as $1.
+type HeaderRewrite struct {
+	Name        string `json:"name"`
+	Pattern     string `json:"pattern"`
+	Replacement string `json:"replacement"`
+}
+
+type Rewriter struct {
+	rules            RewriteRules
+	requestPatterns  []*regexp.Regexp
+	responsePatterns []*regexp.Regexp
+}
+
+// NewRewriter checks the rules and prepares them for use.
+func NewRewriter(rules RewriteRules) (*Rewriter, error) {
+	rewriter := &Rewriter{rules: rules}
+	var err error
+	if rewriter.requestPatterns, err = compileRewrites("requestHeaders", rules.RequestHeaders.Rewrite); err != nil {
+		return nil, err
+	}
+	if rewriter.responsePatterns, err = compileRewrites("responseHeaders", rules.ResponseHeaders.Rewrite); err != nil {
+		return nil, err
+	}
+	for _, prefix := range []string{rules.StripPrefix, rules.AddPrefix} {
+		if len(prefix) != 0 && (!strings.HasPrefix(prefix, "/") || strings.HasSuffix(prefix, "/")) {
+			return nil, fmt.Errorf("invalid path prefix '%s', must start with '/' and not end with one", prefix)
+		}
+	}
+	return rewriter, nil
+}
+
+func compileRewrites(section string, rewrites []HeaderRewrite) ([]*regexp.Regexp, error) {
+	var patterns []*regexp.Regexp
+	for i, rewrite := range rewrites {
+		if len(rewrite.Name) == 0 {
+			return nil, fmt.Errorf("%s rewrite %d: a header name is needed", section, i+1)
+		}
+		pattern, err := regexp.Compile(rewrite.Pattern)
+		if err != nil {
+			return nil, fmt.Errorf("%s rewrite %d: invalid pattern: %s", section, i+1, err)
+		}
+		patterns = append(patterns, pattern)
+	}
+	return patterns, nil
+}
+
+func (rw *Rewriter) Middleware() Middleware {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			publicHost, publicScheme := r.Host, r.Header.Get("X-Forwarded-Proto")
+			if publicScheme == "" {
+				publicScheme = "http"
+			}
+			host := applyHeaderRules(r.Header, r.Host, rw.rules.RequestHeaders, rw.requestPatterns)
+			r.Host = host
+			if len(rw.rules.StripPrefix) != 0 {
+				r.URL.Path = trimPathPrefix(r.URL.Path, rw.rules.StripPrefix)
+			}
+			if len(rw.rules.AddPrefix) != 0 {
+				r.URL.Path = rw.rules.AddPrefix + r.URL.Path
+			}
+			r.URL.RawPath = ""
+			next.ServeHTTP(&rewritingResponse{ResponseWriter: w, rewriter: rw, publicHost: publicHost, publicScheme: publicScheme, localHost: host}, r)
+		})
+	}
+}
+
+func trimPathPrefix(path string, prefix string) string {
+	if path == prefix {
+		return "/"
+	}
+	if strings.HasPrefix(path, prefix+"/") {
+		return strings.TrimPrefix(path, prefix)
+	}
+	return path
+}
+
+// Apply the rules to the headers, returning the (possibly changed) host.
+func applyHeaderRules(headers http.Header, host string, rules HeaderRules, patterns []*regexp.Regexp) string {
+	for _, name := range rules.Remove {
+		headers.Del(name)
+	}
+	for _, name := range sortedNames(rules.Set) {
+		if strings.EqualFold(name, "Host") {
+			host = rules.Set[name]
+		} else {
+			headers.Set(name, rules.Set[name])
+		}
+	}
+	for _, name := range sortedNames(rules.Add) {
+		headers.Add(name, rules.Add[name])
+	}
+	for i, rewrite := range rules.Rewrite {
+		if strings.EqualFold(rewrite.Name, "Host") {
+			host = patterns[i].ReplaceAllString(host, rewrite.Replacement)
+			continue
+		}
+		values := headers[http.CanonicalHeaderKey(rewrite.Name)]
+		for j, value := range values {
+			values[j] = patterns[i].ReplaceAllString(value, rewrite.Replacement)
+		}
+	}
+	return host
+}
+
+func sortedNames(values map[string]string) []string {
+	names := make([]string, 0, len(values))
+	for name := range values {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// rewritingResponse applies the response rules as the headers are sent
+type rewritingResponse struct {
+	http.ResponseWriter
+	rewriter     *Rewriter
+	publicHost   string
+	publicScheme string
+	localHost    string
+	written      bool
+}
+
+func (r *rewritingResponse) WriteHeader(status int) {
+	if !r.written {
+		r.written = true
+		rules := r.rewriter.rules
+		if rules.RewriteLocation {
+			if location := r.Header().Get("Location"); location != "" {
+				r.Header().Set("Location", r.location(location))
+			}
+		}
+		applyHeaderRules(r.Header(), "", rules.ResponseHeaders, r.rewriter.responsePatterns)
+	}
+	r.ResponseWriter.WriteHeader(status)
+}
+
+func (r *rewritingResponse) Write(data []byte) (int, error) {
+	if !r.written {
+		r.WriteHeader(http.StatusOK)
+	}
+	return r.ResponseWriter.Write(data)
+}
+
+func (r *rewritingResponse) Flush() {
+	if flusher, ok := r.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+// The forwarder writes upgrade responses (e.g. for websockets) straight to the hijacked connection,
+// they go back as the local app sent them.
+func (r *rewritingResponse) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	hijacker, ok := r.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, errors.New("connection cannot be hijacked")
+	}
+	r.written = true
+	return hijacker.Hijack()
+}
+
+func (r *rewritingResponse) location(location string) string {
+	u, err := url.Parse(location)
+	if err != nil {
+		return location
+	}
+	if u.IsAbs() {
+		if !isLocalHost(u.Host) && !strings.EqualFold(u.Host, r.localHost) {
+			return location
+		}
+		u.Scheme, u.Host = r.publicScheme, r.publicHost
+	} else if !strings.HasPrefix(u.Path, "/") || len(u.Host) != 0 {
+		return location
+	}
+	rules := r.rewriter.rules
+	if len(rules.AddPrefix) != 0 {
+		u.Path = trimPathPrefix(u.Path, rules.AddPrefix)
+	}
+	if len(rules.StripPrefix) != 0 {
+		u.Path = rules.StripPrefix + u.Path
+	}
+	u.RawPath = ""
+	return u.String()
+}
+
+func isLocalHost(hostPort string) bool {
+	host := hostPort
+	if h, _, err := net.SplitHostPort(hostPort); err == nil {
+		host = h
+	}
+	if strings.EqualFold(host, "localhost") {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
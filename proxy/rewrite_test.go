@@ -0,0 +1,196 @@
+/*
+ * Copyright (C) 2018-Present Pivotal Software, Inc. All rights reserved.
+ *
+ * This program and the accompanying materials are made available under
+ * the terms of the under the Apache License, Version 2.0 (the "License”);
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package proxy
+
+import (
+	"bufio"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+)
+
+// Run a request through the rewriter, returning the response and the request the local app saw
+func rewritten(rules RewriteRules, r *http.Request, app http.HandlerFunc) (*httptest.ResponseRecorder, *http.Request) {
+	rewriter, err := NewRewriter(rules)
+	if err != nil {
+		panic(err)
+	}
+	var passed *http.Request
+	handler := rewriter.Middleware()(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		passed = r
+		if app != nil {
+			app(w, r)
+		}
+	}))
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, r)
+	return recorder, passed
+}
+
+func TestRewritePrefixValidation(t *testing.T) {
+	tests := []struct {
+		prefix string
+		valid  bool
+	}{
+		{"", true},
+		{"/api", true},
+		{"/api/v1", true},
+		{"/", false},
+		{"api", false},
+		{"/api/", false},
+	}
+	for _, test := range tests {
+		for _, rules := range []RewriteRules{{StripPrefix: test.prefix}, {AddPrefix: test.prefix}} {
+			if _, err := NewRewriter(rules); (err == nil) != test.valid {
+				t.Errorf("prefix '%s': got error %v, want valid %v", test.prefix, err, test.valid)
+			}
+		}
+	}
+}
+
+func TestRewritePaths(t *testing.T) {
+	tests := []struct {
+		name        string
+		stripPrefix string
+		addPrefix   string
+		path        string
+		want        string
+	}{
+		{"no prefixes", "", "", "/orders", "/orders"},
+		{"strip", "/api", "", "/api/orders", "/orders"},
+		{"strip the whole path", "/api", "", "/api", "/"},
+		{"strip only whole segments", "/api", "", "/apiary/bees", "/apiary/bees"},
+		{"strip not matching", "/api", "", "/orders", "/orders"},
+		{"add", "", "/v1", "/orders", "/v1/orders"},
+		{"strip and add", "/api", "/v1", "/api/orders", "/v1/orders"},
+	}
+	for _, test := range tests {
+		_, passed := rewritten(RewriteRules{StripPrefix: test.stripPrefix, AddPrefix: test.addPrefix}, httptest.NewRequest("GET", test.path, nil), nil)
+		if passed.URL.Path != test.want {
+			t.Errorf("%s: %s became %s, want %s", test.name, test.path, passed.URL.Path, test.want)
+		}
+	}
+}
+
+func TestRewriteRequestHeaders(t *testing.T) {
+	rules := RewriteRules{RequestHeaders: HeaderRules{
+		Remove:  []string{"Cookie"},
+		Set:     map[string]string{"Host": "localhost:8080", "X-Env": "dev"},
+		Add:     map[string]string{"X-Developer": "andy"},
+		Rewrite: []HeaderRewrite{{Name: "Authorization", Pattern: "^Bearer (.*)$", Replacement: "Token $1"}},
+	}}
+	r := httptest.NewRequest("GET", "https://fortunes.example.com/", nil)
+	r.Header.Set("Cookie", "session=1")
+	r.Header.Set("X-Env", "prod")
+	r.Header.Set("X-Developer", "chris")
+	r.Header.Set("Authorization", "Bearer abc")
+	_, passed := rewritten(rules, r, nil)
+
+	if passed.Host != "localhost:8080" {
+		t.Errorf("got host %s, want localhost:8080", passed.Host)
+	}
+	if passed.Header.Get("Cookie") != "" {
+		t.Error("Cookie was not removed")
+	}
+	if passed.Header.Get("X-Env") != "dev" {
+		t.Errorf("got X-Env %s, want dev", passed.Header.Get("X-Env"))
+	}
+	if developers := passed.Header["X-Developer"]; len(developers) != 2 || developers[1] != "andy" {
+		t.Errorf("got X-Developer %v, want [chris andy]", developers)
+	}
+	if passed.Header.Get("Authorization") != "Token abc" {
+		t.Errorf("got Authorization %s, want Token abc", passed.Header.Get("Authorization"))
+	}
+}
+
+func TestRewriteLocation(t *testing.T) {
+	tests := []struct {
+		name     string
+		location string
+		want     string
+	}{
+		{"local app", "http://localhost:8080/orders/1", "https://fortunes.example.com/api/orders/1"},
+		{"loopback address", "http://127.0.0.1:8080/orders?page=2", "https://fortunes.example.com/api/orders?page=2"},
+		{"host the local app was sent", "http://fortunes.internal/orders", "https://fortunes.example.com/api/orders"},
+		{"absolute path", "/orders", "/api/orders"},
+		{"another host", "https://login.example.com/authorize", "https://login.example.com/authorize"},
+		{"relative path", "orders/1", "orders/1"},
+		{"scheme relative", "//login.example.com/authorize", "//login.example.com/authorize"},
+	}
+	rules := RewriteRules{
+		StripPrefix:     "/api",
+		RewriteLocation: true,
+		RequestHeaders:  HeaderRules{Set: map[string]string{"Host": "fortunes.internal"}},
+		ResponseHeaders: HeaderRules{Remove: []string{"Server"}},
+	}
+	for _, test := range tests {
+		r := httptest.NewRequest("GET", "http://fortunes.example.com/api/orders", nil)
+		r.Header.Set("X-Forwarded-Proto", "https")
+		response, _ := rewritten(rules, r, func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Server", "local")
+			w.Header().Set("Location", test.location)
+			w.WriteHeader(http.StatusFound)
+		})
+		if got := response.Header().Get("Location"); got != test.want {
+			t.Errorf("%s: %s became %s, want %s", test.name, test.location, got, test.want)
+		}
+		if response.Header().Get("Server") != "" {
+			t.Errorf("%s: Server header was not removed", test.name)
+		}
+	}
+}
+
+// Upgraded connections pass through the rewriter, the upgrade response as the local app sent it
+func TestRewriteUpgrade(t *testing.T) {
+	app := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, buffered, err := w.(http.Hijacker).Hijack()
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		defer conn.Close()
+		buffered.WriteString("HTTP/1.1 101 Switching Protocols\r\nConnection: Upgrade\r\nUpgrade: test\r\nServer: local\r\n\r\n")
+		buffered.Flush()
+	}))
+	defer app.Close()
+	target, _ := url.Parse(app.URL)
+	rewriter, err := NewRewriter(RewriteRules{ResponseHeaders: HeaderRules{Remove: []string{"Server"}, Set: map[string]string{"X-Rewritten": "true"}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tunnel := httptest.NewServer(rewriter.Middleware()(NewForwarder(target, http.DefaultTransport)))
+	defer tunnel.Close()
+
+	conn, err := net.Dial("tcp", tunnel.Listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.Write([]byte("GET / HTTP/1.1\r\nHost: fortunes.example.com\r\nConnection: Upgrade\r\nUpgrade: test\r\n\r\n"))
+	response, err := http.ReadResponse(bufio.NewReader(conn), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if response.StatusCode != http.StatusSwitchingProtocols {
+		t.Fatalf("got %d, want %d", response.StatusCode, http.StatusSwitchingProtocols)
+	}
+	if response.Header.Get("Server") != "local" || response.Header.Get("X-Rewritten") != "" {
+		t.Errorf("upgrade response headers were changed: %v", response.Header)
+	}
+}
@@ -0,0 +1,130 @@
+/*
+ * Copyright (C) 2018-Present Pivotal Software, Inc. All rights reserved.
+ *
+ * This program and the accompanying materials are made available under
+ * the terms of the under the Apache License, Version 2.0 (the "License”);
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package proxy
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"io/ioutil"
+	"log"
+	"math/rand"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	ServedByReal   = "real"
+	ServedByMirror = "mirror"
+)
+
+// Bodies larger than this are not mirrored
+const MaxMirroredBody = 10 * 1024 * 1024
+
+// How many mirrored requests may be waiting on the local app before more are dropped
+const maxMirrorsInFlight = 32
+
+// ServedBy marks the requests passing through as served by the side.
+func ServedBy(side string) Middleware {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			markServedBy(w, r, side)
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+func markServedBy(w http.ResponseWriter, r *http.Request, side string) {
+	if exchange := ExchangeFrom(r); exchange != nil {
+		exchange.ServedBy = side
+	}
+	w.Header().Set(ServedByHeader, side)
+}
+
+// Split sends a percentage of the requests to the local app and the rest to the deployed app.
+type Split struct {
+	Local   http.Handler
+	Remote  http.Handler
+	Percent int
+}
+
+func (s *Split) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if rand.Intn(100) < s.Percent {
+		markServedBy(w, r, ServedByLocal)
+		s.Local.ServeHTTP(w, r)
+		return
+	}
+	markServedBy(w, r, ServedByReal)
+	s.Remote.ServeHTTP(w, r)
+}
+
+// Mirror answers callers from the deployed app (Primary) and sends a copy of each request to the local
+// app (Shadow) in the background, whose response is thrown away.
+type Mirror struct {
+	Primary http.Handler
+	Shadow  http.Handler
+
+	inFlight int32
+	dropped  int64
+}
+
+func (m *Mirror) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	body, err := ioutil.ReadAll(io.LimitReader(r.Body, MaxMirroredBody+1))
+	if err != nil {
+		http.Error(w, "tunnel-boot: unable to read request: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	if len(body) > MaxMirroredBody {
+		// Too big to hold on to, the deployed app gets it without a copy being made
+		r.Body = struct {
+			io.Reader
+			io.Closer
+		}{io.MultiReader(bytes.NewReader(body), r.Body), r.Body}
+	} else {
+		r.Body = ioutil.NopCloser(bytes.NewReader(body))
+		m.mirror(r, body)
+	}
+	markServedBy(w, r, ServedByReal)
+	m.Primary.ServeHTTP(w, r)
+}
+
+func (m *Mirror) mirror(r *http.Request, body []byte) {
+	if atomic.AddInt32(&m.inFlight, 1) > maxMirrorsInFlight {
+		atomic.AddInt32(&m.inFlight, -1)
+		if dropped := atomic.AddInt64(&m.dropped, 1); dropped == 1 || dropped%100 == 0 {
+			log.Printf("[%s] the local app is not keeping up, %d mirrored requests dropped so far", ServedByMirror, dropped)
+		}
+		return
+	}
+	// The copy must not be cancelled when the caller's request completes
+	shadow := r.WithContext(context.Background())
+	shadowUrl := *r.URL
+	shadow.URL = &shadowUrl
+	shadow.Header = cloneHeader(r.Header)
+	shadow.Body = ioutil.NopCloser(bytes.NewReader(body))
+	shadow.ContentLength = int64(len(body))
+	go func() {
+		defer atomic.AddInt32(&m.inFlight, -1)
+		recorder := httptest.NewRecorder()
+		started := time.Now()
+		m.Shadow.ServeHTTP(recorder, shadow)
+		log.Printf("[%s] %s %s -> local app %d in %.1f ms", ServedByMirror, shadow.Method, shadow.URL.RequestURI(),
+			recorder.Code, float64(time.Since(started))/float64(time.Millisecond))
+	}()
+}
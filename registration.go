@@ -0,0 +1,161 @@
+/*
+ * Copyright (C) 2018-Present Pivotal Software, Inc. All rights reserved.
+ *
+ * This program and the accompanying materials are made available under
+ * the terms of the under the Apache License, Version 2.0 (the "License”);
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/aclement/tunnel-boot/format"
+	"github.com/aclement/tunnel-boot/scs"
+)
+
+const (
+	heartbeatInterval   = 30 * time.Second
+	leaseDurationInSecs = 90
+	metadataEnvPrefix   = "eureka.instance.metadataMap."
+)
+
+// Register the tunnel application in the bound service registry directly from the plugin, rather than
+// relying on the sidecar in the tunnel application. The registration is kept alive (and marked UP or
+// DOWN depending on whether the local app is listening) until the tunnel stops, when it is removed.
+func (p *Plugin) registerTunnel(applicationName string, guid string, target *localTarget, hooks *shutdownHooks) {
+	client := p.registryClient(applicationName)
+	instance := p.tunnelInstance(applicationName, guid)
+
+	fmt.Println("Registering", instance.InstanceId, "as", instance.App, "in the service registry")
+	if err := client.Register(instance); err != nil {
+		format.Diagnose(string(err.Error()), os.Stderr, func() {
+			os.Exit(1)
+		})
+	}
+
+	stop := make(chan struct{})
+	done := make(chan struct{})
+	go heartbeat(client, instance, target, stop, done)
+
+	hooks.add(func() {
+		close(stop)
+		<-done
+		fmt.Println("Deregistering", instance.InstanceId, "from the service registry")
+		if err := client.Deregister(instance.App, instance.InstanceId); err != nil {
+			log.Println("Problem deregistering from the service registry:", err)
+		}
+	})
+}
+
+// Build a client for the service registry bound to the tunnel application
+func (p *Plugin) registryClient(applicationName string) *scs.Client {
+	return p.serviceClient(applicationName, scs.ServiceRegistryLabels)
+}
+
+// Describe the tunnel application as a registry instance: it is reached through its route and also
+// advertises the container address. Metadata set at push time is carried over.
+func (p *Plugin) tunnelInstance(applicationName string, guid string) *scs.Instance {
+	routes := p.deployer.GetRoutes(applicationName)
+	if len(routes) == 0 {
+		format.Diagnose("Tunnel application "+applicationName+" has no routes to register", os.Stderr, func() {
+			os.Exit(1)
+		})
+	}
+	route := strings.SplitN(routes[0], "/", 2)[0]
+
+	env := p.deployer.GetUserProvidedEnv(applicationName)
+	springApplicationName := springApplicationNameFromEnvironment(env, applicationName)
+	metadata := make(map[string]string)
+	for key, value := range env {
+		if strings.HasPrefix(key, metadataEnvPrefix) {
+			metadata[strings.TrimPrefix(key, metadataEnvPrefix)] = fmt.Sprint(value)
+		}
+	}
+	metadata[tunnelMetadataKey] = "true"
+
+	return &scs.Instance{
+		InstanceId:       route + ":" + guid,
+		HostName:         route,
+		App:              strings.ToUpper(springApplicationName),
+		IpAddr:           p.deployer.FetchInetAddr(applicationName),
+		Status:           scs.StatusUp,
+		Port:             scs.Port{Number: 80, Enabled: "true"},
+		SecurePort:       scs.Port{Number: 443, Enabled: "true"},
+		VipAddress:       springApplicationName,
+		SecureVipAddress: springApplicationName,
+		HomePageUrl:      "https://" + route + "/",
+		DataCenterInfo:   scs.DefaultDataCenterInfo,
+		LeaseInfo:        &scs.LeaseInfo{RenewalIntervalInSecs: int(heartbeatInterval.Seconds()), DurationInSecs: leaseDurationInSecs},
+		Metadata:         metadata,
+	}
+}
+
+// The name the tunnel application registers under, as set by push-tunnel-app --spring-app-name
+func springApplicationNameFromEnvironment(env map[string]interface{}, applicationName string) string {
+	if name, ok := env["spring.application.name"].(string); ok && name != "" {
+		return name
+	}
+	return applicationName
+}
+
+// The hosts of the tunnel application's routes, registrations using them are served by this tunnel
+func (p *Plugin) tunnelHosts(applicationName string) map[string]bool {
+	hosts := make(map[string]bool)
+	for _, route := range p.deployer.GetRoutes(applicationName) {
+		hosts[strings.SplitN(route, "/", 2)[0]] = true
+	}
+	return hosts
+}
+
+func isTunnelInstance(instance scs.Instance, tunnelHosts map[string]bool) bool {
+	return instance.Metadata[tunnelMetadataKey] == "true" || tunnelHosts[instance.HostName]
+}
+
+// Renew the lease regularly, reflecting whether the local app is accepting connections in the
+// instance status. If the registry has forgotten us (e.g. it restarted) register again.
+func heartbeat(client *scs.Client, instance *scs.Instance, target *localTarget, stop <-chan struct{}, done chan<- struct{}) {
+	defer close(done)
+	status := scs.StatusUp
+	ticker := time.NewTicker(heartbeatInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+
+		newStatus := scs.StatusDown
+		if target.listening() {
+			newStatus = scs.StatusUp
+		}
+		err := client.Heartbeat(instance.App, instance.InstanceId)
+		if scs.IsNotFound(err) {
+			instance.Status = newStatus
+			err = client.Register(instance)
+			status = newStatus
+		} else if err == nil && newStatus != status {
+			log.Println("Local application is", newStatus, "- updating service registry status")
+			if err = client.SetStatus(instance.App, instance.InstanceId, newStatus); err == nil {
+				status = newStatus
+			}
+		}
+		if err != nil {
+			log.Println("Problem renewing service registry lease:", err)
+		}
+	}
+}
@@ -0,0 +1,83 @@
+/*
+ * Copyright (C) 2018-Present Pivotal Software, Inc. All rights reserved.
+ *
+ * This program and the accompanying materials are made available under
+ * the terms of the under the Apache License, Version 2.0 (the "License”);
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/aclement/tunnel-boot/format"
+	"github.com/aclement/tunnel-boot/scs"
+)
+
+// List the contents of the service registry bound to the tunnel application, flagging the
+// instances that are served by a tunnel (and so by someone's local machine).
+func (p *Plugin) serviceRegistryList(applicationName string) format.Action {
+	return func(progressWriter io.Writer) (string, error) {
+		client := p.registryClient(applicationName)
+		apps, err := client.Applications()
+		if err != nil {
+			return "", err
+		}
+		tunnelHosts := p.tunnelHosts(applicationName)
+
+		sort.Slice(apps, func(i, j int) bool { return apps[i].Name < apps[j].Name })
+		output := &bytes.Buffer{}
+		table := tabwriter.NewWriter(output, 0, 8, 2, ' ', 0)
+		fmt.Fprintln(table, "application\tinstance id\tstatus\ttunnel\tmetadata")
+		for _, app := range apps {
+			for _, instance := range app.Instance {
+				tunnel := ""
+				if isTunnelInstance(instance, tunnelHosts) {
+					tunnel = "<- tunnel"
+					if tunnelHosts[instance.HostName] {
+						tunnel = "<- this tunnel"
+					}
+				}
+				fmt.Fprintf(table, "%s\t%s\t%s\t%s\t%s\n", app.Name, instance.InstanceId, instanceStatus(instance), tunnel, formatMetadata(instance.Metadata))
+			}
+		}
+		table.Flush()
+		if len(apps) == 0 {
+			return "No applications are registered\n", nil
+		}
+		return output.String(), nil
+	}
+}
+
+func instanceStatus(instance scs.Instance) string {
+	if instance.OverriddenStatus != "" && instance.OverriddenStatus != "UNKNOWN" && instance.OverriddenStatus != instance.Status {
+		return instance.Status + " (overridden " + instance.OverriddenStatus + ")"
+	}
+	return instance.Status
+}
+
+func formatMetadata(metadata map[string]string) string {
+	var entries []string
+	for key, value := range metadata {
+		if key == "@class" {
+			continue
+		}
+		entries = append(entries, key+"="+value)
+	}
+	sort.Strings(entries)
+	return strings.Join(entries, ",")
+}
@@ -0,0 +1,184 @@
+/*
+ * Copyright (C) 2018-Present Pivotal Software, Inc. All rights reserved.
+ *
+ * This program and the accompanying materials are made available under
+ * the terms of the under the Apache License, Version 2.0 (the "License”);
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+
+	"github.com/aclement/tunnel-boot/proxy"
+	"github.com/aclement/tunnel-boot/session"
+)
+
+// Resend a request captured by a running start-tunnel --inspect to the local app. The replay is made
+// by the inspector itself so it reaches the app exactly as tunneled requests do.
+func (p *Plugin) tunnelReplay(applicationName string, requestId string, flags map[string]string) func() (string, error) {
+	return func() (string, error) {
+		s, err := session.Load(applicationName)
+		if err != nil {
+			return "", err
+		}
+		if s == nil || len(s.Inspector) == 0 {
+			return "", fmt.Errorf("No tunnel session with an inspector found for %s, start one with: cf start-tunnel %s LOCAL_PORT --inspect PORT", applicationName, applicationName)
+		}
+		times := 1
+		if value, ok := flags["times"]; ok {
+			times, _ = strconv.Atoi(value)
+			if times < 1 {
+				return "", fmt.Errorf("Invalid times '%s': must be at least 1", value)
+			}
+		}
+
+		inspector := "http://" + s.Inspector
+		exchange := &proxy.Exchange{}
+		if err = inspectorCall(inspector, "GET", "/api/requests/"+requestId, nil, exchange); err != nil {
+			return "", fmt.Errorf("Unable to fetch request %s: %s", requestId, err)
+		}
+		replay := &proxy.ReplayRequest{ReplayOf: exchange.Id, Request: exchange.Request}
+		if flags["edit"] == "true" {
+			if replay.Request, err = editRequest(replay.Request); err != nil {
+				return "", err
+			}
+		}
+
+		result := &proxy.ReplayResult{}
+		for i := 1; i <= times; i++ {
+			if err = inspectorCall(inspector, "POST", "/api/replay", replay, result); err != nil {
+				return "", fmt.Errorf("Replay failed: %s", err)
+			}
+			fmt.Printf("%s %s -> %d %s in %.1f ms (captured as #%s)\n", replay.Request.Method, replay.Request.Url,
+				result.Response.Status, http.StatusText(result.Response.Status), result.DurationMs, result.Id)
+		}
+		if result.Response.BodyEncoding == "base64" {
+			return fmt.Sprintf("(%d bytes of binary response body)\n", result.Response.BodySize), nil
+		}
+		return result.Response.Body, nil
+	}
+}
+
+// Call the inspector's JSON API, errors it reports are returned as they are.
+func inspectorCall(inspector string, method string, path string, request interface{}, response interface{}) error {
+	var body bytes.Buffer
+	if request != nil {
+		if err := json.NewEncoder(&body).Encode(request); err != nil {
+			return err
+		}
+	}
+	req, err := http.NewRequest(method, inspector+path, &body)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("unable to reach the inspector at %s, is start-tunnel still running? %s", inspector, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		failure := map[string]string{}
+		json.NewDecoder(resp.Body).Decode(&failure)
+		return fmt.Errorf("%s", failure["error"])
+	}
+	return json.NewDecoder(resp.Body).Decode(response)
+}
+
+// Let the user change the request in $EDITOR, as HTTP/1.1 text.
+func editRequest(request proxy.Message) (proxy.Message, error) {
+	if request.BodyEncoding == "base64" {
+		return request, fmt.Errorf("The request has a binary body, it can only be replayed unchanged")
+	}
+	if request.BodyTruncated {
+		return request, fmt.Errorf("The body of the request was only partially captured (%d bytes), it cannot be replayed", request.BodySize)
+	}
+	file, err := ioutil.TempFile("", "tunnel-replay-*.http")
+	if err != nil {
+		return request, err
+	}
+	defer os.Remove(file.Name())
+	text := request.Method + " " + request.Url + " HTTP/1.1\n"
+	text += "Host: " + request.Host + "\n"
+	for name, values := range request.Headers {
+		for _, value := range values {
+			text += name + ": " + value + "\n"
+		}
+	}
+	text += "\n" + request.Body
+	_, err = file.WriteString(text)
+	file.Close()
+	if err != nil {
+		return request, err
+	}
+
+	editor := os.Getenv("VISUAL")
+	if editor == "" {
+		editor = os.Getenv("EDITOR")
+	}
+	if editor == "" {
+		editor = "vi"
+	}
+	// The editor setting may include arguments (e.g. "code --wait")
+	editorArgs := strings.Fields(editor)
+	cmd := exec.Command(editorArgs[0], append(editorArgs[1:], file.Name())...)
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = os.Stdin, os.Stdout, os.Stderr
+	if err = cmd.Run(); err != nil {
+		return request, fmt.Errorf("Editor '%s' failed: %s", editor, err)
+	}
+	edited, err := ioutil.ReadFile(file.Name())
+	if err != nil {
+		return request, err
+	}
+	return parseEditedRequest(string(edited))
+}
+
+func parseEditedRequest(text string) (proxy.Message, error) {
+	request := proxy.Message{Proto: "HTTP/1.1", Headers: http.Header{}}
+	text = strings.Replace(text, "\r\n", "\n", -1)
+	head, body := text, ""
+	if i := strings.Index(text, "\n\n"); i >= 0 {
+		head, body = text[:i], text[i+2:]
+	}
+	lines := strings.Split(head, "\n")
+	requestLine := strings.Fields(lines[0])
+	if len(requestLine) < 2 || !strings.HasPrefix(requestLine[1], "/") {
+		return request, fmt.Errorf("Invalid request line '%s', expected 'METHOD /path HTTP/1.1'", lines[0])
+	}
+	request.Method, request.Url = requestLine[0], requestLine[1]
+	for _, line := range lines[1:] {
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+		nameValue := strings.SplitN(line, ":", 2)
+		if len(nameValue) != 2 {
+			return request, fmt.Errorf("Invalid header '%s', expected 'Name: value'", line)
+		}
+		name, value := http.CanonicalHeaderKey(strings.TrimSpace(nameValue[0])), strings.TrimSpace(nameValue[1])
+		if name == "Host" {
+			request.Host = value
+		} else {
+			request.Headers.Add(name, value)
+		}
+	}
+	request.SetBody([]byte(body), int64(len(body)))
+	return request, nil
+}
@@ -0,0 +1,161 @@
+/*
+ * Copyright (C) 2018-Present Pivotal Software, Inc. All rights reserved.
+ *
+ * This program and the accompanying materials are made available under
+ * the terms of the under the Apache License, Version 2.0 (the "License”);
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package main
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aclement/tunnel-boot/proxy"
+	"github.com/aclement/tunnel-boot/scs"
+)
+
+// Per developer routing: the tunnel application registers with the developer's name in its eureka
+// metadata, and the tunnel's local proxy routes on the X-Tunnel-Developer header. Whichever tunnel a
+// request arrives at, it is passed to the named developer's tunnel (found in the registry) and requests
+// without the header go to the real application.
+const (
+	developerRoutingHeader   = proxy.DeveloperHeader
+	developerMetadataKey     = "developer"
+	routingHeaderMetadataKey = "routingHeader"
+)
+
+// Every registration made for a tunnel application carries this metadata so it can be told apart
+// from the real instances
+const tunnelMetadataKey = "tunnelBoot"
+
+// How long a developer's tunnel found in the registry is used before looking it up again
+const developerTunnelCacheDuration = 30 * time.Second
+
+// Look in the tunnel application's environment for the developer tag set by push-tunnel-app --developer.
+func developerFromEnvironment(env map[string]interface{}) string {
+	if developer, ok := env["eureka.instance.metadataMap."+developerMetadataKey].(string); ok {
+		return developer
+	}
+	return ""
+}
+
+// Route requests between this developer's local app, the other developers' tunnels and the real
+// application, which is reached through realRoute or else a route found in the registry.
+func (p *Plugin) developerRouter(developer string, tunnelApp string, local http.Handler, realRoute string, failed func(string)) http.Handler {
+	tunnels := &developerTunnels{
+		client:                p.registryClient(tunnelApp),
+		springApplicationName: springApplicationNameFromEnvironment(p.deployer.GetUserProvidedEnv(tunnelApp), tunnelApp),
+		tunnelHosts:           p.tunnelHosts(tunnelApp),
+		skipSslValidation:     p.deployer.SkipSslValidation(),
+		found:                 make(map[string]foundTunnel),
+	}
+	if len(realRoute) == 0 {
+		route, err := tunnels.realRoute()
+		if err != nil {
+			failed(err.Error())
+		}
+		realRoute = route
+	}
+	real, realName, err := routeForwarder(realRoute, tunnels.tunnelHosts, tunnels.skipSslValidation)
+	if err != nil {
+		failed(err.Error())
+	}
+	return &proxy.DeveloperRouter{
+		Developer: developer,
+		Local:     local,
+		Real:      real,
+		RealName:  realName,
+		Tunnel:    tunnels.lookup,
+	}
+}
+
+func printDeveloperRouting(developer string) {
+	fmt.Printf("Requests carrying this header are routed to %s's local app, whichever tunnel for the service they reach:\n", developer)
+	fmt.Printf("  %s: %s\n", developerRoutingHeader, developer)
+	fmt.Println("Requests for other developers are passed to their tunnels and requests without the header to the real application.")
+	fmt.Println("Real instances don't look at the header, start the tunnel with --exclusive so that the tunnels get all the requests.")
+}
+
+// The tunnels other developers have running for the same service, found through the service registry
+type developerTunnels struct {
+	client                *scs.Client
+	springApplicationName string
+	tunnelHosts           map[string]bool
+	skipSslValidation     bool
+
+	mutex sync.Mutex
+	found map[string]foundTunnel
+}
+
+type foundTunnel struct {
+	handler http.Handler
+	name    string
+	expires time.Time
+}
+
+// Find the developer's tunnel, it must be registered and UP
+func (t *developerTunnels) lookup(developer string) (http.Handler, string, error) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	if found, ok := t.found[developer]; ok && time.Now().Before(found.expires) {
+		return found.handler, found.name, nil
+	}
+	instances, err := t.instances()
+	if err != nil {
+		return nil, "", fmt.Errorf("unable to look up the tunnel for %s: %s", developer, err)
+	}
+	for _, instance := range instances {
+		if !isTunnelInstance(instance, t.tunnelHosts) || instance.Metadata[developerMetadataKey] != developer || instance.Status != scs.StatusUp {
+			continue
+		}
+		handler, name, err := routeForwarder(instance.HostName, t.tunnelHosts, t.skipSslValidation)
+		if err != nil {
+			return nil, "", err
+		}
+		t.found[developer] = foundTunnel{handler: handler, name: name, expires: time.Now().Add(developerTunnelCacheDuration)}
+		return handler, name, nil
+	}
+	return nil, "", fmt.Errorf("no tunnel for developer %s is registered for %s", developer, t.springApplicationName)
+}
+
+// The route of one of the real instances. Instances registered by their container address can't be
+// reached from here.
+func (t *developerTunnels) realRoute() (string, error) {
+	instances, err := t.instances()
+	if err != nil {
+		return "", err
+	}
+	for _, instance := range instances {
+		if !isTunnelInstance(instance, t.tunnelHosts) && len(instance.HostName) != 0 && net.ParseIP(instance.HostName) == nil {
+			return instance.HostName, nil
+		}
+	}
+	return "", fmt.Errorf("No real instance of %s is registered by its route, supply the real application's route with --real-route", t.springApplicationName)
+}
+
+func (t *developerTunnels) instances() ([]scs.Instance, error) {
+	apps, err := t.client.Applications()
+	if err != nil {
+		return nil, err
+	}
+	for _, app := range apps {
+		if strings.EqualFold(app.Name, t.springApplicationName) {
+			return app.Instance, nil
+		}
+	}
+	return nil, nil
+}
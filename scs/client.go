@@ -0,0 +1,175 @@
+/*
+ * Copyright (C) 2018-Present Pivotal Software, Inc. All rights reserved.
+ *
+ * This program and the accompanying materials are made available under
+ * the terms of the under the Apache License, Version 2.0 (the "License”);
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package scs
+
+import (
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// Client makes OAuth authenticated requests to a Spring Cloud Services instance.
+type Client struct {
+	credentials *Credentials
+	httpClient  *http.Client
+	token       *Token
+	cache       *TokenCache
+}
+
+// Token is an OAuth access token obtained with the client credentials grant.
+type Token struct {
+	AccessToken string    `json:"access_token"`
+	ExpiresIn   int       `json:"expires_in"`
+	Expiry      time.Time `json:"expiry"`
+}
+
+// NewClient creates a client for the service described by the credentials. skipSslValidation
+// should mirror the cf CLI setting (cf api --skip-ssl-validation).
+func NewClient(credentials *Credentials, skipSslValidation bool) *Client {
+	return &Client{
+		credentials: credentials,
+		httpClient: &http.Client{
+			Timeout: 30 * time.Second,
+			Transport: &http.Transport{
+				Proxy:           http.ProxyFromEnvironment,
+				TLSClientConfig: &tls.Config{InsecureSkipVerify: skipSslValidation},
+			},
+		},
+	}
+}
+
+// UseTokenCache makes the client reuse (and save) tokens in the cache.
+func (c *Client) UseTokenCache(cache *TokenCache) {
+	c.cache = cache
+}
+
+// Token returns a valid access token, fetching a new one when there is none or it is about to expire.
+func (c *Client) Token() (*Token, error) {
+	if c.token != nil && c.token.valid() {
+		return c.token, nil
+	}
+	if c.cache != nil {
+		if token := c.cache.Lookup(c.credentials); token != nil {
+			c.token = token
+			return token, nil
+		}
+	}
+	form := url.Values{"grant_type": {"client_credentials"}}
+	req, err := http.NewRequest("POST", c.credentials.AccessTokenUri, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	req.SetBasicAuth(url.QueryEscape(c.credentials.ClientId), url.QueryEscape(c.credentials.ClientSecret))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to obtain access token: %s", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		body, _ := ioutil.ReadAll(resp.Body)
+		return nil, fmt.Errorf("Unable to obtain access token: %s %s", resp.Status, string(body))
+	}
+	token := &Token{}
+	if err = json.NewDecoder(resp.Body).Decode(token); err != nil {
+		return nil, fmt.Errorf("Unable to parse access token response: %s", err)
+	}
+	token.Expiry = time.Now().Add(time.Duration(token.ExpiresIn) * time.Second)
+	c.token = token
+	if c.cache != nil {
+		c.cache.Store(c.credentials, token)
+	}
+	return token, nil
+}
+
+// Do sends an authenticated request to the path (relative to the service uri). The caller must close the
+// response body. Responses outside the 2xx range are returned as errors.
+func (c *Client) Do(method string, path string, contentType string, body io.Reader) (*http.Response, error) {
+	req, err := c.NewRequest(method, path, body)
+	if err != nil {
+		return nil, err
+	}
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+	return c.Send(req)
+}
+
+// NewRequest creates a request for the path (relative to the service uri) that accepts json by default.
+func (c *Client) NewRequest(method string, path string, body io.Reader) (*http.Request, error) {
+	req, err := http.NewRequest(method, c.credentials.Uri+path, body)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "application/json")
+	return req, nil
+}
+
+// Send adds the bearer token to the request and sends it, as for Do.
+func (c *Client) Send(req *http.Request) (*http.Response, error) {
+	token, err := c.Token()
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Authorization", "Bearer "+token.AccessToken)
+	method, path := req.Method, req.URL.RequestURI()
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		defer resp.Body.Close()
+		data, _ := ioutil.ReadAll(resp.Body)
+		return nil, &HttpError{
+			StatusCode: resp.StatusCode,
+			Message:    fmt.Sprintf("%s %s failed: %s %s", method, path, resp.Status, strings.TrimSpace(string(data))),
+		}
+	}
+	return resp, nil
+}
+
+// HttpError is returned when the service responds with a non 2xx status.
+type HttpError struct {
+	StatusCode int
+	Message    string
+}
+
+func (e *HttpError) Error() string {
+	return e.Message
+}
+
+// IsNotFound reports whether the error is a 404 response from the service.
+func IsNotFound(err error) bool {
+	httpError, ok := err.(*HttpError)
+	return ok && httpError.StatusCode == http.StatusNotFound
+}
+
+func (c *Client) doAndClose(method string, path string, contentType string, body io.Reader) error {
+	resp, err := c.Do(method, path, contentType, body)
+	if err != nil {
+		return err
+	}
+	io.Copy(ioutil.Discard, resp.Body)
+	return resp.Body.Close()
+}
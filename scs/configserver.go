@@ -0,0 +1,83 @@
+/*
+ * Copyright (C) 2018-Present Pivotal Software, Inc. All rights reserved.
+ *
+ * This program and the accompanying materials are made available under
+ * the terms of the under the Apache License, Version 2.0 (the "License”);
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package scs
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"strings"
+)
+
+// CipherPrefix marks an encrypted value in a config server property source
+const CipherPrefix = "{cipher}"
+
+// Encrypt uses the config server's /encrypt endpoint to encrypt the value with the server's key.
+func (c *Client) Encrypt(value string) (string, error) {
+	return c.postText("/encrypt", value)
+}
+
+// Decrypt uses the config server's /decrypt endpoint. A leading {cipher} marker is ignored.
+func (c *Client) Decrypt(cipherText string) (string, error) {
+	return c.postText("/decrypt", strings.TrimPrefix(strings.TrimSpace(cipherText), CipherPrefix))
+}
+
+func (c *Client) postText(path string, text string) (string, error) {
+	resp, err := c.Do("POST", path, "text/plain", strings.NewReader(text))
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	data, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return "", err
+	}
+	return string(data), nil
+}
+
+// Environment is the config server's view of the configuration for an application and profiles.
+// Property sources are in precedence order, the first source defining a property wins.
+type Environment struct {
+	Name            string           `json:"name"`
+	Profiles        []string         `json:"profiles"`
+	Label           string           `json:"label"`
+	Version         string           `json:"version"`
+	PropertySources []PropertySource `json:"propertySources"`
+}
+
+type PropertySource struct {
+	Name   string                 `json:"name"`
+	Source map[string]interface{} `json:"source"`
+}
+
+// Environment fetches /{application}/{profiles} from the config server.
+func (c *Client) Environment(application string, profiles string) (*Environment, error) {
+	resp, err := c.Do("GET", "/"+url.PathEscape(application)+"/"+url.PathEscape(profiles), "", nil)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	environment := &Environment{}
+	// Keep numbers as they were written, rather than as float64s (which print large ones as 1e+06)
+	decoder := json.NewDecoder(resp.Body)
+	decoder.UseNumber()
+	if err = decoder.Decode(environment); err != nil {
+		return nil, fmt.Errorf("Unable to parse config server environment: %s", err)
+	}
+	return environment, nil
+}
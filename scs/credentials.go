@@ -0,0 +1,76 @@
+/*
+ * Copyright (C) 2018-Present Pivotal Software, Inc. All rights reserved.
+ *
+ * This program and the accompanying materials are made available under
+ * the terms of the under the Apache License, Version 2.0 (the "License”);
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package scs
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// Service labels used in VCAP_SERVICES by the Spring Cloud Services tiles (SCS 1.x/2.x and 3.x)
+var (
+	ServiceRegistryLabels = []string{"p-service-registry", "p.service-registry"}
+	ConfigServerLabels    = []string{"p-config-server", "p.config-server"}
+)
+
+// Credentials are the OAuth client credentials and service endpoint of a Spring Cloud Services binding.
+type Credentials struct {
+	Uri            string `json:"uri"`
+	ClientId       string `json:"client_id"`
+	ClientSecret   string `json:"client_secret"`
+	AccessTokenUri string `json:"access_token_uri"`
+}
+
+type serviceBinding struct {
+	Name        string      `json:"name"`
+	Label       string      `json:"label"`
+	Credentials Credentials `json:"credentials"`
+}
+
+// FindCredentials returns the credentials of the first binding in the VCAP_SERVICES json with one of the
+// given labels. A service instance name may be supplied instead of a label.
+func FindCredentials(vcapServices string, labels []string) (*Credentials, error) {
+	var services map[string][]serviceBinding
+	if err := json.Unmarshal([]byte(vcapServices), &services); err != nil {
+		return nil, fmt.Errorf("Unable to parse VCAP_SERVICES: %s", err)
+	}
+	for _, label := range labels {
+		for _, binding := range services[label] {
+			return validate(binding)
+		}
+	}
+	for _, bindings := range services {
+		for _, binding := range bindings {
+			for _, label := range labels {
+				if binding.Name == label {
+					return validate(binding)
+				}
+			}
+		}
+	}
+	return nil, fmt.Errorf("No service bound with %s", strings.Join(labels, " or "))
+}
+
+func validate(binding serviceBinding) (*Credentials, error) {
+	c := binding.Credentials
+	if c.Uri == "" || c.ClientId == "" || c.ClientSecret == "" || c.AccessTokenUri == "" {
+		return nil, fmt.Errorf("Service %s does not have uri, client_id, client_secret and access_token_uri credentials", binding.Name)
+	}
+	c.Uri = strings.TrimSuffix(c.Uri, "/")
+	return &c, nil
+}
@@ -0,0 +1,134 @@
+/*
+ * Copyright (C) 2018-Present Pivotal Software, Inc. All rights reserved.
+ *
+ * This program and the accompanying materials are made available under
+ * the terms of the under the Apache License, Version 2.0 (the "License”);
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package scs
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"strings"
+)
+
+// Instance statuses understood by eureka
+const (
+	StatusUp           = "UP"
+	StatusDown         = "DOWN"
+	StatusOutOfService = "OUT_OF_SERVICE"
+)
+
+// Instance is the eureka REST representation of a registered application instance.
+type Instance struct {
+	InstanceId       string            `json:"instanceId"`
+	HostName         string            `json:"hostName"`
+	App              string            `json:"app"`
+	IpAddr           string            `json:"ipAddr"`
+	Status           string            `json:"status"`
+	OverriddenStatus string            `json:"overriddenstatus,omitempty"`
+	Port             Port              `json:"port"`
+	SecurePort       Port              `json:"securePort"`
+	VipAddress       string            `json:"vipAddress"`
+	SecureVipAddress string            `json:"secureVipAddress"`
+	HomePageUrl      string            `json:"homePageUrl,omitempty"`
+	StatusPageUrl    string            `json:"statusPageUrl,omitempty"`
+	HealthCheckUrl   string            `json:"healthCheckUrl,omitempty"`
+	DataCenterInfo   DataCenterInfo    `json:"dataCenterInfo"`
+	LeaseInfo        *LeaseInfo        `json:"leaseInfo,omitempty"`
+	Metadata         map[string]string `json:"metadata,omitempty"`
+}
+
+type Port struct {
+	Number  int    `json:"$"`
+	Enabled string `json:"@enabled"`
+}
+
+type DataCenterInfo struct {
+	Class string `json:"@class"`
+	Name  string `json:"name"`
+}
+
+type LeaseInfo struct {
+	RenewalIntervalInSecs int `json:"renewalIntervalInSecs"`
+	DurationInSecs        int `json:"durationInSecs"`
+}
+
+// Application is a registered application and its instances.
+type Application struct {
+	Name     string     `json:"name"`
+	Instance []Instance `json:"instance"`
+}
+
+// DefaultDataCenterInfo is the data center info used for instances that are not running on AWS.
+var DefaultDataCenterInfo = DataCenterInfo{
+	Class: "com.netflix.appinfo.InstanceInfo$DefaultDataCenterInfo",
+	Name:  "MyOwn",
+}
+
+func appPath(app string) string {
+	return "/eureka/apps/" + url.PathEscape(strings.ToUpper(app))
+}
+
+func instancePath(app string, instanceId string) string {
+	return appPath(app) + "/" + url.PathEscape(instanceId)
+}
+
+// Register adds the instance to the registry.
+func (c *Client) Register(instance *Instance) error {
+	body, err := json.Marshal(map[string]*Instance{"instance": instance})
+	if err != nil {
+		return err
+	}
+	return c.doAndClose("POST", appPath(instance.App), "application/json", bytes.NewReader(body))
+}
+
+// Heartbeat renews the lease of a registered instance.
+func (c *Client) Heartbeat(app string, instanceId string) error {
+	return c.doAndClose("PUT", instancePath(app, instanceId), "", nil)
+}
+
+// Deregister removes the instance from the registry.
+func (c *Client) Deregister(app string, instanceId string) error {
+	return c.doAndClose("DELETE", instancePath(app, instanceId), "", nil)
+}
+
+// SetStatus overrides the status of an instance.
+func (c *Client) SetStatus(app string, instanceId string, status string) error {
+	return c.doAndClose("PUT", instancePath(app, instanceId)+"/status?value="+url.QueryEscape(status), "", nil)
+}
+
+// Applications lists every application (and its instances) in the registry.
+func (c *Client) Applications() ([]Application, error) {
+	resp, err := c.Do("GET", "/eureka/apps", "", nil)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	var apps struct {
+		Applications struct {
+			Application []Application `json:"application"`
+		} `json:"applications"`
+	}
+	if err = json.NewDecoder(resp.Body).Decode(&apps); err != nil {
+		return nil, fmt.Errorf("Unable to parse registry applications: %s", err)
+	}
+	return apps.Applications.Application, nil
+}
+
+// RemoveStatusOverride clears a status set with SetStatus, the instance returns to UP.
+func (c *Client) RemoveStatusOverride(app string, instanceId string) error {
+	return c.doAndClose("DELETE", instancePath(app, instanceId)+"/status?value="+StatusUp, "", nil)
+}
@@ -0,0 +1,81 @@
+/*
+ * Copyright (C) 2018-Present Pivotal Software, Inc. All rights reserved.
+ *
+ * This program and the accompanying materials are made available under
+ * the terms of the under the Apache License, Version 2.0 (the "License”);
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package scs
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// TokenCache keeps access tokens in a file so that they can be reused by later plugin
+// invocations until they expire. Tokens are keyed by token endpoint and client id.
+type TokenCache struct {
+	File string
+}
+
+func cacheKey(credentials *Credentials) string {
+	return credentials.AccessTokenUri + " " + credentials.ClientId
+}
+
+func (tc *TokenCache) read() map[string]*Token {
+	tokens := make(map[string]*Token)
+	data, err := ioutil.ReadFile(tc.File)
+	if err == nil {
+		json.Unmarshal(data, &tokens)
+	}
+	return tokens
+}
+
+// Lookup returns the cached token for the credentials if there is one that is still valid.
+func (tc *TokenCache) Lookup(credentials *Credentials) *Token {
+	token := tc.read()[cacheKey(credentials)]
+	if token == nil || !token.valid() {
+		return nil
+	}
+	return token
+}
+
+// Store saves the token for the credentials, dropping any expired tokens at the same time.
+func (tc *TokenCache) Store(credentials *Credentials, token *Token) error {
+	tokens := tc.read()
+	for key, t := range tokens {
+		if !t.valid() {
+			delete(tokens, key)
+		}
+	}
+	tokens[cacheKey(credentials)] = token
+	data, err := json.Marshal(tokens)
+	if err != nil {
+		return err
+	}
+	if err = os.MkdirAll(filepath.Dir(tc.File), 0700); err != nil {
+		return err
+	}
+	temp := tc.File + ".tmp"
+	if err = ioutil.WriteFile(temp, data, 0600); err != nil {
+		return err
+	}
+	return os.Rename(temp, tc.File)
+}
+
+// A token is only handed out if it has a little life left in it
+func (t *Token) valid() bool {
+	return time.Now().Add(30 * time.Second).Before(t.Expiry)
+}
@@ -0,0 +1,115 @@
+/*
+ * Copyright (C) 2018-Present Pivotal Software, Inc. All rights reserved.
+ *
+ * This program and the accompanying materials are made available under
+ * the terms of the under the Apache License, Version 2.0 (the "License”);
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package session
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"os/user"
+	"path/filepath"
+)
+
+// Session is the state of a running start-tunnel, kept on disk (keyed by the tunnel application
+// name) so that other commands can find it and so it can be cleaned up after a crash.
+type Session struct {
+	TunnelApp    string             `json:"tunnelApp"`
+	OutOfService []RegistryInstance `json:"outOfService,omitempty"`
+	// Address of the request inspector while start-tunnel --inspect is running
+	Inspector string `json:"inspector,omitempty"`
+}
+
+// IsEmpty reports whether there is nothing worth keeping in the session.
+func (s *Session) IsEmpty() bool {
+	return len(s.OutOfService) == 0 && len(s.Inspector) == 0
+}
+
+// RegistryInstance identifies an instance in the service registry.
+type RegistryInstance struct {
+	App        string `json:"app"`
+	InstanceId string `json:"instanceId"`
+}
+
+// PluginDirectory is where the plugin keeps its state, alongside the cf CLI plugins (honouring CF_PLUGIN_HOME).
+func PluginDirectory() string {
+	home := os.Getenv("CF_PLUGIN_HOME")
+	if home == "" {
+		home = os.Getenv("HOME")
+		if u, err := user.Current(); home == "" && err == nil {
+			home = u.HomeDir
+		}
+	}
+	return filepath.Join(home, ".cf", "plugins", "tunnel-boot")
+}
+
+// Directory holding the session files
+func Directory() string {
+	return filepath.Join(PluginDirectory(), "sessions")
+}
+
+func file(tunnelApp string) string {
+	return filepath.Join(Directory(), tunnelApp+".json")
+}
+
+// Load returns the saved session for the tunnel application, or nil if there isn't one.
+func Load(tunnelApp string) (*Session, error) {
+	data, err := ioutil.ReadFile(file(tunnelApp))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	s := &Session{}
+	if err = json.Unmarshal(data, s); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// Save writes the session to disk, replacing any previous state.
+func (s *Session) Save() error {
+	if err := os.MkdirAll(Directory(), 0700); err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return err
+	}
+	temp := file(s.TunnelApp) + ".tmp"
+	if err = ioutil.WriteFile(temp, data, 0600); err != nil {
+		return err
+	}
+	return os.Rename(temp, file(s.TunnelApp))
+}
+
+// SaveOrRemove saves the session, or removes it from disk when it is empty.
+func (s *Session) SaveOrRemove() error {
+	if s.IsEmpty() {
+		return s.Remove()
+	}
+	return s.Save()
+}
+
+// Remove deletes the session from disk.
+func (s *Session) Remove() error {
+	err := os.Remove(file(s.TunnelApp))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	return err
+}
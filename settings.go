@@ -0,0 +1,292 @@
+/*
+ * Copyright (C) 2018-Present Pivotal Software, Inc. All rights reserved.
+ *
+ * This program and the accompanying materials are made available under
+ * the terms of the under the Apache License, Version 2.0 (the "License”);
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// TunnelAppSettings are the manifest customisations for the pushed tunnel application.
+// They can come from a JSON config file (--config-file) and/or individual flags, flags win.
+// Empty values leave the manifest template defaults in place.
+type TunnelAppSettings struct {
+	Memory              string            `json:"memory"`
+	Disk                string            `json:"disk"`
+	Instances           *int              `json:"instances"`
+	Stack               string            `json:"stack"`
+	Buildpack           string            `json:"buildpack"`
+	Env                 map[string]string `json:"env"`
+	HealthCheckType     string            `json:"health-check-type"`
+	HealthCheckEndpoint string            `json:"health-check-endpoint"`
+	RegistryUrl         string            `json:"registry-url"`
+	Metadata            map[string]string `json:"metadata"`
+	Developer           string            `json:"developer"`
+}
+
+// This is the memory the manifest template asks for
+const defaultTunnelAppMemoryMB = 1024
+
+var healthCheckTypes = []string{"port", "process", "http", "none"}
+
+var (
+	developerNamePattern = regexp.MustCompile(`^[A-Za-z0-9._-]+$`)
+	metadataKeyPattern   = regexp.MustCompile(`^[A-Za-z0-9._-]+$`)
+)
+
+// Dots and dashes are allowed for Spring style property names (e.g. spring.profiles.active)
+var envNamePattern = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_.-]*$`)
+
+// Build the settings from the optional config file and then overlay any flags.
+func loadTunnelAppSettings(flags map[string]string, listFlags map[string][]string) (*TunnelAppSettings, error) {
+	settings := &TunnelAppSettings{}
+	if configFile := flags["config-file"]; len(configFile) != 0 {
+		data, err := ioutil.ReadFile(configFile)
+		if err != nil {
+			return nil, fmt.Errorf("Unable to read config file: %s", err)
+		}
+		if err = json.Unmarshal(data, settings); err != nil {
+			return nil, fmt.Errorf("Unable to parse config file %s: %s", configFile, err)
+		}
+	}
+	if settings.Env == nil {
+		settings.Env = make(map[string]string)
+	}
+	if settings.Metadata == nil {
+		settings.Metadata = make(map[string]string)
+	}
+
+	overlay := func(value *string, flagName string) {
+		if v, ok := flags[flagName]; ok {
+			*value = v
+		}
+	}
+	overlay(&settings.Memory, "memory")
+	overlay(&settings.Disk, "disk")
+	overlay(&settings.Stack, "stack")
+	overlay(&settings.Buildpack, "buildpack")
+	overlay(&settings.HealthCheckType, "health-check-type")
+	overlay(&settings.HealthCheckEndpoint, "health-check-endpoint")
+	overlay(&settings.RegistryUrl, "registry-url")
+	overlay(&settings.Developer, "developer")
+	if instances, ok := flags["instances"]; ok {
+		count, err := strconv.Atoi(instances)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid instances '%s': must be at least 1", instances)
+		}
+		settings.Instances = &count
+	}
+	for _, env := range listFlags["env"] {
+		keyValue := strings.SplitN(env, "=", 2)
+		if len(keyValue) != 2 || len(keyValue[0]) == 0 {
+			return nil, fmt.Errorf("Invalid --env value '%s', expected KEY=VALUE", env)
+		}
+		settings.Env[keyValue[0]] = keyValue[1]
+	}
+	for _, metadata := range listFlags["metadata"] {
+		keyValue := strings.SplitN(metadata, "=", 2)
+		if len(keyValue) != 2 {
+			return nil, fmt.Errorf("Invalid --metadata value '%s', expected key=value", metadata)
+		}
+		settings.Metadata[keyValue[0]] = keyValue[1]
+	}
+	return settings, settings.validate()
+}
+
+func (s *TunnelAppSettings) validate() error {
+	if len(s.Memory) != 0 {
+		if _, err := parseMegabytes(s.Memory); err != nil {
+			return fmt.Errorf("Invalid memory '%s': %s", s.Memory, err)
+		}
+	}
+	if len(s.Disk) != 0 {
+		if _, err := parseMegabytes(s.Disk); err != nil {
+			return fmt.Errorf("Invalid disk '%s': %s", s.Disk, err)
+		}
+	}
+	if s.Instances != nil && *s.Instances < 1 {
+		return fmt.Errorf("Invalid instances '%d': must be at least 1", *s.Instances)
+	}
+	// The names are written into the manifest as they are
+	for name := range s.Env {
+		if !envNamePattern.MatchString(name) {
+			return fmt.Errorf("Invalid environment variable name '%s': must start with a letter or '_' and contain only letters, digits, '_', '.' and '-'", name)
+		}
+	}
+	if len(s.HealthCheckType) != 0 {
+		valid := false
+		for _, t := range healthCheckTypes {
+			valid = valid || t == s.HealthCheckType
+		}
+		if !valid {
+			return fmt.Errorf("Invalid health check type '%s', expected one of %s", s.HealthCheckType, strings.Join(healthCheckTypes, ", "))
+		}
+	}
+	if len(s.HealthCheckEndpoint) != 0 {
+		if s.HealthCheckType != "http" {
+			return fmt.Errorf("A health check endpoint can only be used with health check type 'http'")
+		}
+		if !strings.HasPrefix(s.HealthCheckEndpoint, "/") {
+			return fmt.Errorf("Invalid health check endpoint '%s': must start with '/'", s.HealthCheckEndpoint)
+		}
+	}
+	if len(s.RegistryUrl) != 0 && !strings.HasPrefix(s.RegistryUrl, "http://") && !strings.HasPrefix(s.RegistryUrl, "https://") {
+		return fmt.Errorf("Invalid registry url '%s': must be an http or https URL", s.RegistryUrl)
+	}
+	// Metadata keys end up in eureka.instance.metadataMap.KEY in the manifest
+	for key := range s.Metadata {
+		if !metadataKeyPattern.MatchString(key) {
+			return fmt.Errorf("Invalid metadata key '%s': only letters, digits, '.', '-' and '_' are allowed", key)
+		}
+	}
+	if len(s.Developer) != 0 && !developerNamePattern.MatchString(s.Developer) {
+		return fmt.Errorf("Invalid developer '%s': only letters, digits, '.', '-' and '_' are allowed", s.Developer)
+	}
+	return nil
+}
+
+// Check the requested memory fits inside the quotas that apply to the current space, alongside what is already running.
+func (s *TunnelAppSettings) checkQuotas(quotas []QuotaLimits) error {
+	memory := int64(defaultTunnelAppMemoryMB)
+	if len(s.Memory) != 0 {
+		memory, _ = parseMegabytes(s.Memory)
+	}
+	instances := int64(1)
+	if s.Instances != nil {
+		instances = int64(*s.Instances)
+	}
+	for _, quota := range quotas {
+		if quota.InstanceMemoryLimit >= 0 && memory > quota.InstanceMemoryLimit {
+			return fmt.Errorf("Requested memory %dM exceeds the per instance limit of %dM in %s", memory, quota.InstanceMemoryLimit, quota.Description)
+		}
+		if quota.MemoryLimit >= 0 && quota.MemoryUsed+memory*instances > quota.MemoryLimit {
+			return fmt.Errorf("Requested memory %dM x %d instances, with %dM already in use, exceeds the memory limit of %dM in %s",
+				memory, instances, quota.MemoryUsed, quota.MemoryLimit, quota.Description)
+		}
+	}
+	return nil
+}
+
+// Rewrite the template defaults and add the extra attributes to the manifest.
+// The template is expected to end with the application env: block.
+func (s *TunnelAppSettings) applyTo(manifest string) string {
+	lines := strings.Split(manifest, "\n")
+	replace := func(key string, value string) {
+		for i, line := range lines {
+			if strings.HasPrefix(strings.TrimSpace(line), key+":") {
+				lines[i] = "  " + key + ": " + value
+			}
+		}
+	}
+	if len(s.Memory) != 0 {
+		replace("memory", s.Memory)
+	}
+	if s.Instances != nil {
+		replace("instances", strconv.Itoa(*s.Instances))
+	}
+	if len(s.HealthCheckType) != 0 {
+		replace("health-check-type", s.HealthCheckType)
+	}
+
+	var extra []string
+	if len(s.Disk) != 0 {
+		extra = append(extra, "  disk_quota: "+s.Disk)
+	}
+	if len(s.Stack) != 0 {
+		extra = append(extra, "  stack: "+s.Stack)
+	}
+	if len(s.Buildpack) != 0 {
+		extra = append(extra, "  buildpack: "+s.Buildpack)
+	}
+	if len(s.HealthCheckEndpoint) != 0 {
+		extra = append(extra, "  health-check-http-endpoint: "+s.HealthCheckEndpoint)
+	}
+	result := []string{}
+	for _, line := range lines {
+		if strings.TrimSpace(line) == "env:" {
+			result = append(result, extra...)
+		}
+		result = append(result, line)
+	}
+	manifest = strings.Join(result, "\n")
+
+	keys := make([]string, 0, len(s.Env))
+	for key := range s.Env {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		manifest += "    " + key + ": " + strconv.Quote(s.Env[key]) + "\n"
+	}
+	return manifest + s.registryProperties()
+}
+
+// The eureka properties (set through the env block) that control how the tunnel application
+// registers. Any metadata marks the instance as needing explicit routing, so that metadata aware
+// load balancers only send it the requests tagged for it.
+func (s *TunnelAppSettings) registryProperties() string {
+	properties := "    eureka.instance.metadataMap." + tunnelMetadataKey + ": true\n"
+	if len(s.RegistryUrl) != 0 {
+		properties += "    eureka.client.serviceUrl.defaultZone: " + s.RegistryUrl + "\n"
+	}
+	metadata := make(map[string]string)
+	for key, value := range s.Metadata {
+		metadata[key] = value
+	}
+	if len(s.Developer) != 0 {
+		metadata[developerMetadataKey] = s.Developer
+		metadata[routingHeaderMetadataKey] = developerRoutingHeader
+	}
+	if len(metadata) != 0 {
+		properties += "    eureka.instance.metadataMap.needsExplicitRouting: true\n"
+		keys := make([]string, 0, len(metadata))
+		for key := range metadata {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			properties += "    eureka.instance.metadataMap." + key + ": " + strconv.Quote(metadata[key]) + "\n"
+		}
+	}
+	return properties
+}
+
+// Parse a cf style size (512M, 1G, 1024MB, ...) into megabytes.
+func parseMegabytes(size string) (int64, error) {
+	value := strings.ToUpper(strings.TrimSpace(size))
+	value = strings.TrimSuffix(value, "B")
+	multiplier := int64(1)
+	switch {
+	case strings.HasSuffix(value, "G"):
+		multiplier = 1024
+		value = strings.TrimSuffix(value, "G")
+	case strings.HasSuffix(value, "M"):
+		value = strings.TrimSuffix(value, "M")
+	default:
+		return 0, fmt.Errorf("expected a unit of M or G")
+	}
+	amount, err := strconv.ParseInt(value, 10, 64)
+	if err != nil || amount <= 0 {
+		return 0, fmt.Errorf("expected a positive whole number of megabytes or gigabytes")
+	}
+	return amount * multiplier, nil
+}
@@ -0,0 +1,280 @@
+/*
+ * Copyright (C) 2018-Present Pivotal Software, Inc. All rights reserved.
+ *
+ * This program and the accompanying materials are made available under
+ * the terms of the under the Apache License, Version 2.0 (the "License”);
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/aclement/tunnel-boot/format"
+	"github.com/aclement/tunnel-boot/proxy"
+)
+
+const (
+	// How often the local app is probed
+	healthProbeInterval = 3 * time.Second
+	healthProbeTimeout  = 2 * time.Second
+	// ssh doesn't say when the remote forward is in place, once it has stayed up this long the tunnel is taken to be connected
+	tunnelSettleTime = 5 * time.Second
+)
+
+const (
+	tunnelConnecting   = "CONNECTING"
+	tunnelConnected    = "CONNECTED"
+	tunnelReconnecting = "RECONNECTING"
+	localAppDown       = "LOCAL APP DOWN"
+)
+
+// tunnelMonitor probes the local app while the tunnel runs, warning when requests arrive at the tunnel
+// with nothing to answer them. With --status (or a health probe option) it keeps a status line with the
+// request counts up to date, otherwise it reports changes as they happen.
+type tunnelMonitor struct {
+	live     bool
+	probed   string
+	probe    func() error
+	requests *proxy.RequestCounter
+
+	mutex      sync.Mutex
+	tunnel     string
+	since      time.Time
+	localError error
+	probedOnce bool
+	drawn      int32
+	stop       chan struct{}
+	done       chan struct{}
+}
+
+func wantsStatus(flags map[string]string) bool {
+	return flags["status"] == "true" || len(flags["health-port"]) != 0 || len(flags["health-path"]) != 0
+}
+
+// Work out how to probe the local app: a TCP connection to the target (or the --health-port on the same
+// host), or a GET of --health-path which must answer with a 2xx.
+func newTunnelMonitor(target *localTarget, flags map[string]string) (*tunnelMonitor, error) {
+	m := &tunnelMonitor{tunnel: tunnelConnecting, since: time.Now(), live: wantsStatus(flags) && isTerminal(os.Stdout)}
+	probeTarget := target
+	if port, ok := flags["health-port"]; ok {
+		if err := checkPort(port); err != nil {
+			return nil, fmt.Errorf("Invalid health port '%s': %s", port, err)
+		}
+		probeTarget = &localTarget{network: "tcp", address: net.JoinHostPort(target.hostName(), port)}
+	}
+	if path, ok := flags["health-path"]; ok {
+		if !strings.HasPrefix(path, "/") {
+			path = "/" + path
+		}
+		m.useHealthEndpoint(&url.URL{Scheme: "http", Host: probeTarget.hostPort(), Path: path}, &http.Transport{DialContext: probeTarget.dial})
+		return m, nil
+	}
+	m.probed = probeTarget.String()
+	m.probe = func() error {
+		ctx, cancel := context.WithTimeout(context.Background(), healthProbeTimeout)
+		defer cancel()
+		conn, err := probeTarget.dial(ctx, probeTarget.network, probeTarget.address)
+		if err != nil {
+			return err
+		}
+		return conn.Close()
+	}
+	return m, nil
+}
+
+// Probe the health endpoint with the transport given, e.g. the HTTP proxy's when the local app uses TLS
+func (m *tunnelMonitor) useHealthEndpoint(endpoint *url.URL, transport *http.Transport) {
+	transport.DisableKeepAlives = true
+	client := &http.Client{Transport: transport, Timeout: healthProbeTimeout}
+	m.probed = endpoint.String()
+	m.probe = func() error {
+		resp, err := client.Get(endpoint.String())
+		if err != nil {
+			if urlErr, ok := err.(*url.Error); ok {
+				return urlErr.Err
+			}
+			return err
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+			io.Copy(ioutil.Discard, resp.Body)
+			return nil
+		}
+		// Spring Boot actuator says why in the body
+		var health struct {
+			Status string `json:"status"`
+		}
+		if json.NewDecoder(io.LimitReader(resp.Body, 64*1024)).Decode(&health); len(health.Status) != 0 {
+			return fmt.Errorf("health check answered %s, status %s", resp.Status, health.Status)
+		}
+		return fmt.Errorf("health check answered %s", resp.Status)
+	}
+}
+
+// Probe until the tunnel stops
+func (m *tunnelMonitor) start(hooks *shutdownHooks) {
+	m.stop = make(chan struct{})
+	m.done = make(chan struct{})
+	if m.live {
+		// Log lines must not land on the end of the status line
+		log.SetOutput(m.writer(os.Stderr))
+	}
+	go m.run()
+	hooks.add(func() {
+		close(m.stop)
+		<-m.done
+		m.clearLine()
+		log.SetOutput(os.Stderr)
+	})
+}
+
+func (m *tunnelMonitor) run() {
+	defer close(m.done)
+	probe := time.NewTimer(0)
+	defer probe.Stop()
+	redraw := time.NewTicker(time.Second)
+	defer redraw.Stop()
+	for {
+		select {
+		case <-m.stop:
+			return
+		case <-probe.C:
+			m.checkLocalApp()
+			probe.Reset(healthProbeInterval)
+		case <-redraw.C:
+			m.mutex.Lock()
+			m.draw()
+			m.mutex.Unlock()
+		}
+	}
+}
+
+func (m *tunnelMonitor) checkLocalApp() {
+	err := m.probe()
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	wasUp := m.probedOnce && m.localError == nil
+	wasDown := m.probedOnce && m.localError != nil
+	m.localError = err
+	m.probedOnce = true
+	if (wasUp && err != nil) || (wasDown && err == nil) {
+		m.since = time.Now()
+	}
+	switch {
+	case err != nil && !wasDown:
+		if m.tunnel == tunnelConnected {
+			m.warnNothingListening()
+		} else {
+			m.report("The local app is not answering at %s: %s", m.probed, err)
+		}
+	case err == nil && !wasUp:
+		m.report("The local app is answering at %s", m.probed)
+	}
+	m.draw()
+}
+
+// Called as the ssh connection comes and goes
+func (m *tunnelMonitor) setTunnelState(state string) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	if state == m.tunnel {
+		return
+	}
+	m.tunnel = state
+	m.since = time.Now()
+	if state == tunnelConnected && m.probedOnce && m.localError != nil {
+		m.warnNothingListening()
+	}
+	m.draw()
+}
+
+func (m *tunnelMonitor) warnNothingListening() {
+	m.report("%s the tunnel is up but the local app is not answering at %s (%s), requests will fail until it is",
+		format.Bold(format.Red("WARNING:")), m.probed, m.localError)
+}
+
+// Print a line of its own, the status line is drawn again after it
+func (m *tunnelMonitor) report(message string, a ...interface{}) {
+	m.clearLine()
+	log.Printf(message, a...)
+}
+
+func (m *tunnelMonitor) state() string {
+	if m.tunnel == tunnelConnected && m.probedOnce && m.localError != nil {
+		return localAppDown
+	}
+	return m.tunnel
+}
+
+func (m *tunnelMonitor) draw() {
+	if !m.live {
+		return
+	}
+	var state string
+	switch m.state() {
+	case tunnelConnected:
+		state = format.Bold(format.Green(tunnelConnected))
+	case localAppDown:
+		state = format.Bold(format.Red(localAppDown))
+	default:
+		state = format.Bold(format.Cyan(m.tunnel))
+	}
+	line := fmt.Sprintf("%s for %s | %s", state, time.Since(m.since).Truncate(time.Second), m.probed)
+	if m.requests != nil {
+		counts := m.requests.Counts()
+		line += fmt.Sprintf(" | requests: %d, failed: %d, in flight: %d", counts.Total, counts.Failed, counts.InFlight)
+	}
+	fmt.Print("\r\033[K" + line)
+	atomic.StoreInt32(&m.drawn, 1)
+}
+
+// Clearing doesn't need the lock, log output from anywhere goes through it
+func (m *tunnelMonitor) clearLine() {
+	if atomic.SwapInt32(&m.drawn, 0) == 1 {
+		fmt.Print("\r\033[K")
+	}
+}
+
+// Output written through here doesn't land on the end of the status line
+func (m *tunnelMonitor) writer(out io.Writer) io.Writer {
+	return &statusLineWriter{monitor: m, out: out}
+}
+
+// statusLineWriter moves the status line out of the way of other output
+type statusLineWriter struct {
+	monitor *tunnelMonitor
+	out     io.Writer
+}
+
+func (w *statusLineWriter) Write(data []byte) (int, error) {
+	w.monitor.clearLine()
+	return w.out.Write(data)
+}
+
+func isTerminal(file *os.File) bool {
+	info, err := file.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
@@ -0,0 +1,137 @@
+/*
+ * Copyright (C) 2018-Present Pivotal Software, Inc. All rights reserved.
+ *
+ * This program and the accompanying materials are made available under
+ * the terms of the under the Apache License, Version 2.0 (the "License”);
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// localTarget is where the tunnel delivers requests: a TCP address (localhost:LOCAL_PORT, or any host:port
+// given with --target, e.g. a container or VM) or a unix socket (--target unix:/path/to.sock).
+type localTarget struct {
+	network string
+	address string
+}
+
+const unixTargetPrefix = "unix:"
+
+func parseLocalTarget(value string) (*localTarget, error) {
+	if strings.HasPrefix(value, unixTargetPrefix) {
+		path := strings.TrimPrefix(value, unixTargetPrefix)
+		if len(path) == 0 {
+			return nil, fmt.Errorf("Invalid target '%s', expected unix:/path/to/socket", value)
+		}
+		info, err := os.Stat(path)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid target '%s': %s", value, err)
+		}
+		if info.Mode()&os.ModeSocket == 0 {
+			return nil, fmt.Errorf("Invalid target '%s': %s is not a unix socket", value, path)
+		}
+		return &localTarget{network: "unix", address: path}, nil
+	}
+
+	host, port, err := net.SplitHostPort(value)
+	if err != nil || len(host) == 0 {
+		return nil, fmt.Errorf("Invalid target '%s', expected HOST:PORT or unix:/path/to/socket", value)
+	}
+	if err = checkPort(port); err != nil {
+		return nil, fmt.Errorf("Invalid target '%s': %s", value, err)
+	}
+	if net.ParseIP(host) == nil {
+		if _, err = net.LookupHost(host); err != nil {
+			return nil, fmt.Errorf("Invalid target '%s': unable to resolve %s", value, host)
+		}
+	}
+	return &localTarget{network: "tcp", address: value}, nil
+}
+
+func localPortTarget(port string) (*localTarget, error) {
+	if err := checkPort(port); err != nil {
+		return nil, fmt.Errorf("Invalid local port '%s': %s", port, err)
+	}
+	return &localTarget{network: "tcp", address: net.JoinHostPort("localhost", port)}, nil
+}
+
+func checkPort(port string) error {
+	number, err := strconv.Atoi(port)
+	if err != nil || number < 1 || number > 65535 {
+		return fmt.Errorf("the port must be a number from 1 to 65535")
+	}
+	return nil
+}
+
+func (t *localTarget) String() string {
+	if t.network == "unix" {
+		return unixTargetPrefix + t.address
+	}
+	return t.address
+}
+
+// The host to use in URLs (and TLS server names) for the target
+func (t *localTarget) hostPort() string {
+	if t.network == "unix" {
+		return "localhost"
+	}
+	return t.address
+}
+
+func (t *localTarget) hostName() string {
+	host, _, err := net.SplitHostPort(t.hostPort())
+	if err != nil {
+		return t.hostPort()
+	}
+	return host
+}
+
+// Connect to the target whatever address is asked for, explaining failures in terms of the target.
+func (t *localTarget) dial(ctx context.Context, network string, address string) (net.Conn, error) {
+	conn, err := (&net.Dialer{Timeout: 10 * time.Second}).DialContext(ctx, t.network, t.address)
+	if err == nil {
+		return conn, nil
+	}
+	switch {
+	case errors.Is(err, syscall.ECONNREFUSED):
+		return nil, fmt.Errorf("nothing is accepting connections at %s, is the app running?", t)
+	case errors.Is(err, os.ErrNotExist):
+		return nil, fmt.Errorf("the socket %s does not exist, is the app running?", t.address)
+	case errors.Is(err, os.ErrPermission):
+		return nil, fmt.Errorf("not allowed to connect to %s", t)
+	}
+	if netErr, ok := err.(net.Error); ok && netErr.Timeout() {
+		return nil, fmt.Errorf("timed out connecting to %s, is the host reachable?", t)
+	}
+	return nil, fmt.Errorf("unable to connect to %s: %s", t, err)
+}
+
+// Whether something is accepting connections at the target
+func (t *localTarget) listening() bool {
+	conn, err := net.DialTimeout(t.network, t.address, 2*time.Second)
+	if err != nil {
+		return false
+	}
+	conn.Close()
+	return true
+}
@@ -0,0 +1,115 @@
+/*
+ * Copyright (C) 2018-Present Pivotal Software, Inc. All rights reserved.
+ *
+ * This program and the accompanying materials are made available under
+ * the terms of the under the Apache License, Version 2.0 (the "License”);
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package main
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/aclement/tunnel-boot/format"
+	"github.com/aclement/tunnel-boot/scs"
+	"github.com/aclement/tunnel-boot/session"
+)
+
+// Build a client for a Spring Cloud Services instance bound to the tunnel application. Access tokens
+// are cached on disk until they expire so repeated commands don't keep asking for new ones.
+func (p *Plugin) serviceClient(applicationName string, serviceLabels []string) *scs.Client {
+	vars := processVars(p.deployer.GetEnvVars(applicationName))
+	credentials, err := scs.FindCredentials(vars["VCAP_SERVICES"], serviceLabels)
+	if err != nil {
+		format.Diagnose(string(err.Error()), os.Stderr, func() {
+			os.Exit(1)
+		})
+	}
+	client := scs.NewClient(credentials, p.deployer.SkipSslValidation())
+	client.UseTokenCache(&scs.TokenCache{File: filepath.Join(session.PluginDirectory(), "tokens.json")})
+	return client
+}
+
+// The SERVICE argument is a service instance name or label, 'service-registry' and 'config-server'
+// are accepted as shorthand for whichever Spring Cloud Services version is bound.
+func serviceLabels(service string) []string {
+	switch service {
+	case "service-registry":
+		return scs.ServiceRegistryLabels
+	case "config-server":
+		return scs.ConfigServerLabels
+	}
+	return []string{service}
+}
+
+func (p *Plugin) tunnelToken(applicationName string, service string) func() (string, error) {
+	return func() (string, error) {
+		token, err := p.serviceClient(applicationName, serviceLabels(service)).Token()
+		if err != nil {
+			return "", err
+		}
+		return token.AccessToken, nil
+	}
+}
+
+// Make an authenticated request to the service, curl style: -X method, -d data (or @file) and -H headers.
+func (p *Plugin) tunnelCurl(applicationName string, service string, path string, flags map[string]string, headers []string) func() (string, error) {
+	return func() (string, error) {
+		if !strings.HasPrefix(path, "/") {
+			path = "/" + path
+		}
+		method := "GET"
+		var body io.Reader
+		if data, ok := flags["data"]; ok {
+			method = "POST"
+			if strings.HasPrefix(data, "@") {
+				contents, err := ioutil.ReadFile(data[1:])
+				if err != nil {
+					return "", err
+				}
+				data = string(contents)
+			}
+			body = strings.NewReader(data)
+		}
+		if m, ok := flags["method"]; ok {
+			method = strings.ToUpper(m)
+		}
+
+		client := p.serviceClient(applicationName, serviceLabels(service))
+		req, err := client.NewRequest(method, path, body)
+		if err != nil {
+			return "", err
+		}
+		if body != nil {
+			req.Header.Set("Content-Type", "application/json")
+		}
+		for _, header := range headers {
+			nameValue := strings.SplitN(header, ":", 2)
+			if len(nameValue) != 2 {
+				return "", fmt.Errorf("Invalid header '%s', expected 'Name: value'", header)
+			}
+			req.Header.Set(strings.TrimSpace(nameValue[0]), strings.TrimSpace(nameValue[1]))
+		}
+		resp, err := client.Send(req)
+		if err != nil {
+			return "", err
+		}
+		defer resp.Body.Close()
+		output, err := ioutil.ReadAll(resp.Body)
+		return string(output), err
+	}
+}
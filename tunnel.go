@@ -0,0 +1,337 @@
+/*
+ * Copyright (C) 2018-Present Pivotal Software, Inc. All rights reserved.
+ *
+ * This program and the accompanying materials are made available under
+ * the terms of the under the Apache License, Version 2.0 (the "License”);
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"os/exec"
+	"os/signal"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/aclement/tunnel-boot/format"
+	"github.com/aclement/tunnel-boot/session"
+	"github.com/aclement/tunnel-boot/tunnel"
+)
+
+// Run the reverse ssh tunnel from port 8080 in the tunnel application to the local port (or --target). This keeps
+// running (reconnecting if the connection drops) until the tunnel fails or the user interrupts it, any shutdown
+// hooks registered along the way (e.g. registry cleanup) are run before exiting.
+func (p *Plugin) startTunnel(applicationName string, localPort string, flags map[string]string, listFlags map[string][]string) {
+	var target *localTarget
+	var err error
+	if value, ok := flags["target"]; ok {
+		target, err = parseLocalTarget(value)
+	} else {
+		target, err = localPortTarget(localPort)
+	}
+	var monitor *tunnelMonitor
+	if err == nil {
+		monitor, err = newTunnelMonitor(target, flags)
+	}
+	if err != nil {
+		format.Diagnose(string(err.Error()), os.Stderr, func() {
+			os.Exit(1)
+		})
+	}
+
+	code := p.deployer.GetSshCode()
+	guid := p.deployer.GetGuid(applicationName)
+
+	fmt.Println("The guid for the app is " + guid)
+	fmt.Println("The one time ssh code is " + code)
+	developer := developerFromEnvironment(p.deployer.GetUserProvidedEnv(applicationName))
+	if len(developer) != 0 {
+		printDeveloperRouting(developer)
+	}
+
+	sigs := make(chan os.Signal, 1)
+
+	signal.Notify(sigs, syscall.SIGINT, syscall.SIGTERM)
+
+	// Anything left by an earlier session (e.g. instances still out of service) is kept for tunnel-recover
+	s, err := session.Load(applicationName)
+	if err != nil {
+		format.Diagnose(string(err.Error()), os.Stderr, func() {
+			os.Exit(1)
+		})
+	}
+	if s == nil {
+		s = &session.Session{TunnelApp: applicationName}
+	}
+	s.Inspector = ""
+	hooks := &shutdownHooks{}
+	hooks.add(func() {
+		s.Inspector = ""
+		if err := s.SaveOrRemove(); err != nil {
+			log.Println(err)
+		}
+	})
+
+	// HTTP features (and targets other than a local port) need the tunnel to end at the local proxy,
+	// which passes requests on to the app. It also routes the requests for a developer's tunnel.
+	tunnelPort := localPort
+	if needsHttpProxy(flags, listFlags) || len(developer) != 0 {
+		tunnelPort = p.startHttpProxy(target, developer, flags, listFlags, s, monitor, hooks)
+	}
+
+	sshArgs := []string{"-o", "UserKnownHostsFile=/dev/null", "-o", "StrictHostKeyChecking=no", "-o", "ExitOnForwardFailure=yes",
+		"-o", "ServerAliveInterval=15", "-o", "ServerAliveCountMax=3", "-N", "-p", "2222", "cf:" + guid + "/0@ssh.run.pivotal.io", "-R", "*:8080:localhost:" + tunnelPort}
+	// A SOCKS proxy (dynamic forward) on the same ssh connection opens connections from inside the
+	// container, the internal route proxy uses one too (the user's if they asked for one). A port we
+	// pick can be taken before ssh binds it, ssh then fails (ExitOnForwardFailure) and we pick another.
+	socksPort := flags["socks"]
+	pickedSocksPort := len(socksPort) == 0 && len(flags["internal-proxy"]) != 0
+	if pickedSocksPort {
+		socksPort = freePort()
+	}
+	socksDialer := &tunnel.SocksDialer{ProxyAddress: "127.0.0.1:" + socksPort}
+	if len(socksPort) != 0 {
+		sshArgs = append(sshArgs, "-D", socksDialer.ProxyAddress)
+	}
+
+	_, err = exec.LookPath("sshpass")
+	if err != nil {
+		hooks.run()
+		fmt.Printf("Unable to find sshpass, please install it and re-run or execute the following ssh command manually to start the tunnel\n")
+		fmt.Println("  ssh " + strings.Join(sshArgs, " "))
+		fmt.Printf("(supply the sshcode printed above, or create a new one via: cf ssh-code)")
+		os.Exit(1)
+	}
+
+	if flags["register"] == "true" {
+		p.registerTunnel(applicationName, guid, target, hooks)
+	}
+	if flags["exclusive"] == "true" {
+		p.takeRealInstancesOutOfService(applicationName, s, hooks)
+	}
+	if len(flags["internal-proxy"]) != 0 {
+		startInternalProxy(flags["internal-proxy"], socksDialer, hooks)
+	}
+	if len(flags["socks"]) != 0 {
+		printSocksSettings(socksPort)
+	}
+	if len(s.Inspector) != 0 {
+		if err = s.Save(); err != nil {
+			log.Println("Unable to record the session, tunnel-replay will not find it:", err)
+		}
+	}
+
+	fmt.Println("Connecting tunnel, command:\n  sshpass -p " + code + " ssh " + strings.Join(sshArgs, " "))
+	monitor.start(hooks)
+
+	// Ctrl+C usually reaches ssh too but make sure it goes away whatever signal we got
+	interrupted := make(chan struct{})
+	var sshMutex sync.Mutex
+	var sshCmd *exec.Cmd
+	closed := func() {
+		hooks.run()
+		fmt.Println("\nTunnel closed")
+	}
+	go func() {
+		<-sigs
+		close(interrupted)
+		sshMutex.Lock()
+		defer sshMutex.Unlock()
+		if sshCmd != nil && sshCmd.Process != nil {
+			sshCmd.Process.Kill()
+		}
+	}()
+
+	connected := false
+	failures := 0
+	socksPortRetries := 0
+	for {
+		sshMutex.Lock()
+		select {
+		case <-interrupted:
+			sshMutex.Unlock()
+			closed()
+			return
+		default:
+		}
+		sshCmd = exec.Command("sshpass", append([]string{"-p", code, "ssh"}, sshArgs...)...)
+
+		stdoutIn, _ := sshCmd.StdoutPipe()
+		stderrIn, _ := sshCmd.StderrPipe()
+
+		var stdoutBuf, stderrBuf bytes.Buffer
+		var errStdout, errStderr error
+
+		stdout := io.MultiWriter(monitor.writer(os.Stdout), &stdoutBuf)
+		stderr := io.MultiWriter(monitor.writer(os.Stderr), &stderrBuf)
+
+		err = sshCmd.Start()
+		sshMutex.Unlock()
+		if err != nil {
+			hooks.run()
+			log.Fatalf("sshCmd.Start() failed with %s\n", err)
+		}
+		started := time.Now()
+		settled := time.AfterFunc(tunnelSettleTime, func() {
+			monitor.setTunnelState(tunnelConnected)
+		})
+
+		copied := make(chan struct{}, 2)
+		go func() {
+			_, errStdout = io.Copy(stdout, stdoutIn)
+			copied <- struct{}{}
+		}()
+
+		go func() {
+			_, errStderr = io.Copy(stderr, stderrIn)
+			copied <- struct{}{}
+		}()
+
+		<-copied
+		<-copied
+		err = sshCmd.Wait()
+		settled.Stop()
+
+		// If ssh went because of a signal, give the signal a moment to be noticed
+		select {
+		case <-interrupted:
+		case <-time.After(time.Second):
+		}
+		select {
+		case <-interrupted:
+			closed()
+			return
+		default:
+		}
+
+		if pickedSocksPort && socksPortRetries < maxSocksPortRetries && strings.Contains(stderrBuf.String(), localForwardFailure) {
+			socksPortRetries++
+			socksPort = freePort()
+			log.Printf("The port picked for the SOCKS proxy was taken, trying again with port %s", socksPort)
+			socksDialer.SetProxyAddress("127.0.0.1:" + socksPort)
+			sshArgs[len(sshArgs)-1] = socksDialer.ProxyAddress
+			if code, err = p.newSshCode(); err != nil {
+				hooks.run()
+				format.Diagnose("Unable to get a new ssh code to restart the tunnel: "+err.Error(), os.Stderr, func() {
+					os.Exit(1)
+				})
+			}
+			continue
+		}
+
+		// Once the tunnel has been up, a dropped connection is made again (with a new code, they only work once)
+		if time.Since(started) >= tunnelSettleTime {
+			connected = true
+			failures = 0
+		} else {
+			failures++
+		}
+		if connected && failures <= maxReconnectAttempts {
+			monitor.setTunnelState(tunnelReconnecting)
+			log.Printf("The tunnel connection was lost (%v), reconnecting", err)
+			select {
+			case <-interrupted:
+				closed()
+				return
+			case <-time.After(time.Duration(failures+1) * reconnectDelay):
+			}
+			if code, err = p.newSshCode(); err != nil {
+				hooks.run()
+				format.Diagnose("Unable to reconnect the tunnel, getting a new ssh code failed: "+err.Error(), os.Stderr, func() {
+					os.Exit(1)
+				})
+			}
+			continue
+		}
+		hooks.run()
+
+		reason := "ssh exited"
+		if err != nil {
+			reason = err.Error()
+		}
+		message := "The tunnel could not be established: " + reason
+		if connected {
+			message = fmt.Sprintf("The tunnel was lost and %d reconnect attempts failed: %s", maxReconnectAttempts, reason)
+		}
+		if output := lastLines(stderrBuf.String(), sshOutputTailLines); len(output) != 0 {
+			message += "\nssh said:\n" + output
+		} else if errStdout != nil || errStderr != nil {
+			message += "\n(the ssh output could not be captured)"
+		}
+		format.Diagnose(message, os.Stderr, func() {
+			os.Exit(1)
+		})
+	}
+}
+
+// How much of ssh's output is shown when the tunnel fails
+const sshOutputTailLines = 20
+
+// The last lines of the output, without any trailing newline
+func lastLines(output string, count int) string {
+	lines := strings.Split(strings.TrimRight(output, "\r\n"), "\n")
+	if len(lines) > count {
+		lines = lines[len(lines)-count:]
+	}
+	return strings.Join(lines, "\n")
+}
+
+// How many times in a row reconnecting may fail before giving up, waits get longer each time
+const (
+	maxReconnectAttempts = 5
+	reconnectDelay       = 2 * time.Second
+)
+
+// What ssh says when it can't listen on the -D port, and how many other ports are tried
+const (
+	localForwardFailure = "Could not request local forwarding"
+	maxSocksPortRetries = 3
+)
+
+// A new one time ssh code, failures are returned so the tunnel can be cleaned up properly
+func (p *Plugin) newSshCode() (string, error) {
+	output, err := p.cliConnection.CliCommandWithoutTerminalOutput("ssh-code")
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(strings.Join(output, "")), nil
+}
+
+// shutdownHooks collects the cleanup to perform when the tunnel stops, hooks run once in reverse order.
+type shutdownHooks struct {
+	once  sync.Once
+	mutex sync.Mutex
+	hooks []func()
+}
+
+func (s *shutdownHooks) add(hook func()) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.hooks = append(s.hooks, hook)
+}
+
+func (s *shutdownHooks) run() {
+	s.once.Do(func() {
+		s.mutex.Lock()
+		defer s.mutex.Unlock()
+		for i := len(s.hooks) - 1; i >= 0; i-- {
+			s.hooks[i]()
+		}
+	})
+}
@@ -0,0 +1,167 @@
+/*
+ * Copyright (C) 2018-Present Pivotal Software, Inc. All rights reserved.
+ *
+ * This program and the accompanying materials are made available under
+ * the terms of the under the Apache License, Version 2.0 (the "License”);
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package tunnel
+
+import (
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// InternalDomain is the domain of container to container (internal) routes
+const InternalDomain = "apps.internal"
+
+// InternalProxy is an HTTP proxy (supporting CONNECT for HTTPS) for the local app. Requests for
+// hosts in the internal domain are dialed through the tunnel, everything else is dialed directly.
+type InternalProxy struct {
+	Tunnel    *SocksDialer
+	transport *http.Transport
+}
+
+// Headers that apply to a single connection and are not forwarded by proxies
+var hopHeaders = []string{
+	"Connection", "Proxy-Connection", "Keep-Alive", "Proxy-Authenticate", "Proxy-Authorization",
+	"Te", "Trailer", "Transfer-Encoding", "Upgrade",
+}
+
+func NewInternalProxy(tunnel *SocksDialer) *InternalProxy {
+	proxy := &InternalProxy{Tunnel: tunnel}
+	proxy.transport = &http.Transport{
+		Dial:                proxy.dial,
+		MaxIdleConnsPerHost: 4,
+		IdleConnTimeout:     90 * time.Second,
+	}
+	return proxy
+}
+
+// IsInternal reports whether the host (with or without port) is on an internal route.
+func IsInternal(host string) bool {
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	host = strings.TrimSuffix(strings.ToLower(host), ".")
+	return host == InternalDomain || strings.HasSuffix(host, "."+InternalDomain)
+}
+
+func (p *InternalProxy) dial(network string, address string) (net.Conn, error) {
+	if IsInternal(address) {
+		return p.Tunnel.Dial(network, address)
+	}
+	return net.DialTimeout(network, address, 30*time.Second)
+}
+
+func (p *InternalProxy) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodConnect {
+		p.connect(w, r)
+		return
+	}
+	if !r.URL.IsAbs() {
+		http.Error(w, "This is a proxy, requests must use an absolute URL", http.StatusBadRequest)
+		return
+	}
+
+	outgoing := r.WithContext(r.Context())
+	outgoing.RequestURI = ""
+	outgoing.Header = cloneHeader(r.Header)
+	for _, h := range hopHeaders {
+		outgoing.Header.Del(h)
+	}
+	resp, err := p.transport.RoundTrip(outgoing)
+	if err != nil {
+		log.Println("Proxy request to", r.URL.Host, "failed:", err)
+		http.Error(w, err.Error(), http.StatusBadGateway)
+		return
+	}
+	defer resp.Body.Close()
+	for _, h := range hopHeaders {
+		resp.Header.Del(h)
+	}
+	for name, values := range resp.Header {
+		w.Header()[name] = values
+	}
+	w.WriteHeader(resp.StatusCode)
+	io.Copy(w, resp.Body)
+}
+
+// Tunnel a CONNECT (usually https) request
+func (p *InternalProxy) connect(w http.ResponseWriter, r *http.Request) {
+	upstream, err := p.dial("tcp", r.Host)
+	if err != nil {
+		log.Println("Proxy CONNECT to", r.Host, "failed:", err)
+		http.Error(w, err.Error(), http.StatusBadGateway)
+		return
+	}
+	hijacker, ok := w.(http.Hijacker)
+	if !ok {
+		upstream.Close()
+		http.Error(w, "CONNECT not supported", http.StatusInternalServerError)
+		return
+	}
+	client, buffered, err := hijacker.Hijack()
+	if err != nil {
+		upstream.Close()
+		return
+	}
+	client.Write([]byte("HTTP/1.1 200 Connection established\r\n\r\n"))
+	if buffered.Reader.Buffered() > 0 {
+		io.CopyN(upstream, buffered, int64(buffered.Reader.Buffered()))
+	}
+	Pipe(client, upstream)
+}
+
+// Pipe copies data in both directions until either side is done, then closes both.
+func Pipe(a net.Conn, b net.Conn) {
+	var wg sync.WaitGroup
+	wg.Add(2)
+	copyAndClose := func(to net.Conn, from net.Conn) {
+		defer wg.Done()
+		io.Copy(to, from)
+		if tcp, ok := to.(*net.TCPConn); ok {
+			tcp.CloseWrite()
+		} else {
+			to.Close()
+		}
+	}
+	go copyAndClose(a, b)
+	go copyAndClose(b, a)
+	wg.Wait()
+	a.Close()
+	b.Close()
+}
+
+func cloneHeader(h http.Header) http.Header {
+	clone := make(http.Header, len(h))
+	for name, values := range h {
+		clone[name] = append([]string(nil), values...)
+	}
+	return clone
+}
+
+// FreePort finds a local port that is not currently in use.
+func FreePort() (string, error) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return "", err
+	}
+	defer listener.Close()
+	_, port, err := net.SplitHostPort(listener.Addr().String())
+	return port, err
+}
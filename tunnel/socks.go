@@ -0,0 +1,151 @@
+/*
+ * Copyright (C) 2018-Present Pivotal Software, Inc. All rights reserved.
+ *
+ * This program and the accompanying materials are made available under
+ * the terms of the under the Apache License, Version 2.0 (the "License”);
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package tunnel
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// SocksDialer dials connections through a SOCKS5 proxy, here the dynamic forward (ssh -D) of the
+// tunnel's ssh connection, so that connections are opened from inside the tunnel application's
+// container. Host names are passed through unresolved so that they are resolved in the container.
+type SocksDialer struct {
+	ProxyAddress string
+
+	mutex sync.Mutex
+}
+
+// SetProxyAddress moves the dialer to another proxy, e.g. when ssh had to be restarted on a different port.
+func (d *SocksDialer) SetProxyAddress(address string) {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	d.ProxyAddress = address
+}
+
+func (d *SocksDialer) proxyAddress() string {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	return d.ProxyAddress
+}
+
+var socksReplies = map[byte]string{
+	1: "general failure",
+	2: "connection not allowed",
+	3: "network unreachable",
+	4: "host unreachable",
+	5: "connection refused",
+	6: "TTL expired",
+	7: "command not supported",
+	8: "address type not supported",
+}
+
+// Dial opens a tcp connection to address (host:port) via the SOCKS5 proxy.
+func (d *SocksDialer) Dial(network string, address string) (net.Conn, error) {
+	host, portString, err := net.SplitHostPort(address)
+	if err != nil {
+		return nil, err
+	}
+	port, err := strconv.Atoi(portString)
+	if err != nil || port < 1 || port > 65535 {
+		return nil, fmt.Errorf("invalid port in %s", address)
+	}
+	if len(host) > 255 {
+		return nil, fmt.Errorf("host name too long: %s", host)
+	}
+
+	proxyAddress := d.proxyAddress()
+	conn, err := net.DialTimeout("tcp", proxyAddress, 10*time.Second)
+	if err != nil {
+		return nil, fmt.Errorf("unable to reach the tunnel's SOCKS proxy at %s: %s", proxyAddress, err)
+	}
+	conn.SetDeadline(time.Now().Add(30 * time.Second))
+	if err = socksConnect(conn, host, port); err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("unable to connect to %s through the tunnel: %s", address, err)
+	}
+	conn.SetDeadline(time.Time{})
+	return conn, nil
+}
+
+func socksConnect(conn net.Conn, host string, port int) error {
+	// Greeting: version 5, one method, no authentication
+	if _, err := conn.Write([]byte{5, 1, 0}); err != nil {
+		return err
+	}
+	reply := make([]byte, 2)
+	if _, err := io.ReadFull(conn, reply); err != nil {
+		return err
+	}
+	if reply[0] != 5 || reply[1] != 0 {
+		return fmt.Errorf("SOCKS proxy refused the connection")
+	}
+
+	// Connect request with a domain name (or IP) address
+	request := []byte{5, 1, 0}
+	if ip := net.ParseIP(host); ip != nil && ip.To4() != nil {
+		request = append(request, 1)
+		request = append(request, ip.To4()...)
+	} else if ip != nil {
+		request = append(request, 4)
+		request = append(request, ip.To16()...)
+	} else {
+		request = append(request, 3, byte(len(host)))
+		request = append(request, host...)
+	}
+	portBytes := make([]byte, 2)
+	binary.BigEndian.PutUint16(portBytes, uint16(port))
+	request = append(request, portBytes...)
+	if _, err := conn.Write(request); err != nil {
+		return err
+	}
+
+	header := make([]byte, 4)
+	if _, err := io.ReadFull(conn, header); err != nil {
+		return err
+	}
+	if header[1] != 0 {
+		reason, ok := socksReplies[header[1]]
+		if !ok {
+			reason = fmt.Sprintf("error %d", header[1])
+		}
+		return fmt.Errorf("%s", reason)
+	}
+	// Skip the bound address and port
+	var skip int
+	switch header[3] {
+	case 1:
+		skip = net.IPv4len + 2
+	case 4:
+		skip = net.IPv6len + 2
+	case 3:
+		length := make([]byte, 1)
+		if _, err := io.ReadFull(conn, length); err != nil {
+			return err
+		}
+		skip = int(length[0]) + 2
+	default:
+		return fmt.Errorf("unexpected address type %d in SOCKS reply", header[3])
+	}
+	_, err := io.ReadFull(conn, make([]byte, skip))
+	return err
+}